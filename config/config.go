@@ -1,6 +1,13 @@
 package config
 
+import "time"
+
 type AppConfig struct {
-	AllowFix   bool
-	SilentMode bool
+	AllowFix                 bool
+	SilentMode               bool
+	RefuseModifiedMigrations bool
+	AllowOutOfOrder          bool
+	LockTimeout              time.Duration
+	// TemplateVars holds the variables that are available in migration files with the template directive
+	TemplateVars map[string]string
 }
@@ -1,19 +1,61 @@
 package dbrepo
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
 	"github.com/dhanekom/dbmigrator/config"
+	"github.com/dhanekom/dbmigrator/models"
 )
 
 const (
 	DBDRIVER_POSTGRES = "POSTGRES"
 	DBDRIVER_MYSQL    = "MYSQL"
+	DBDRIVER_SQLITE   = "SQLITE"
 )
 
+const (
+	// DefaultLockTimeout is used when AppConfig.LockTimeout is not set
+	DefaultLockTimeout = time.Minute
+	lockRetryInterval  = 500 * time.Millisecond
+	lockNamePrefix     = "dbmigrator_"
+)
+
+const (
+	// DefaultMigrationTable is used when DBConnectionData.MigrationTable is not set
+	DefaultMigrationTable = "schema_migration"
+	// DefaultPostgresSchema is used by the Postgres driver when DBConnectionData.MigrationSchema is not set
+	DefaultPostgresSchema = "public"
+	// HistoryTableSuffix is appended to the name of the migration table to name the history table
+	HistoryTableSuffix = "_history"
+	// versionIndexSuffix is appended to the name of the migration and history tables to name their version index
+	versionIndexSuffix = "_version_idx"
+)
+
+// migrationTableUpgrades lists the columns that were added to the migration table after it was first released. Missing
+// columns are added in this order by SetupMigrationTable
+var migrationTableUpgrades = []string{"checksum", "dirty", "description", "duration_ms", "db_user", "os_user", "hostname", "tool_version"}
+
+// migrationTableColumnDefinitions holds the column definitions of migrationTableUpgrades. The definitions are valid
+// on all supported dbs
+var migrationTableColumnDefinitions = map[string]string{
+	"checksum":     "varchar(64) NOT NULL DEFAULT ''",
+	"dirty":        "boolean NOT NULL DEFAULT false",
+	"description":  "varchar(255) NOT NULL DEFAULT ''",
+	"duration_ms":  "bigint NOT NULL DEFAULT 0",
+	"db_user":      "varchar(128) NOT NULL DEFAULT ''",
+	"os_user":      "varchar(128) NOT NULL DEFAULT ''",
+	"hostname":     "varchar(255) NOT NULL DEFAULT ''",
+	"tool_version": "varchar(64) NOT NULL DEFAULT ''",
+}
+
 type DBConnectionData struct {
 	DBHost     string
 	DBPort     string
@@ -21,145 +63,697 @@ type DBConnectionData struct {
 	DBUser     string
 	DBPassword string
 	DBSSL      string
+	// MigrationTable is the name of the migration table (DefaultMigrationTable if not set). The history table is
+	// named after it with a "_history" suffix
+	MigrationTable string
+	// MigrationSchema is the schema of the migration tables. If it is not set Postgres uses DefaultPostgresSchema and
+	// MySQL uses the database of the connection
+	MigrationSchema string
 }
 
+// DBDriver builds the SQL of a db. Drivers that hold resources between connections (e.g. the in-memory db of the SQLite
+// driver) implement io.Closer. They are closed by DBRepo.Close. AddMigrationTableColumnSQL returns an empty statement
+// for a column that the db does not store, which SetupMigrationTable then skips
 type DBDriver interface {
 	Open(dbConnData DBConnectionData) (*sql.DB, error)
 	SetupMigrationTableSQL() string
 	MigrateDBSQL(migrationDirection string) (string, error)
 	CurrentVersionSQL() string
 	MigratedVersionsSQL() string
+	AppliedMigrationsSQL() string
+	MigrationTableExistsSQL() (string, []any)
+	MigrationTableColumnsSQL() (string, []any)
+	AddMigrationTableColumnSQL(column string) (string, error)
+	TryLockSQL(lockName string) (string, []any)
+	UnlockSQL(lockName string) (string, []any)
+	LockHolderSQL(lockName string) (string, []any)
+	SupportsTransactionalDDL() bool
+	MarkDirtySQL(migrationDirection string) (string, error)
+	ClearDirtySQL(migrationDirection string) (string, error)
+	SplitStatements(script string) ([]Statement, error)
+	ErrorOffset(stmt string, err error) int
+	SetupHistoryTableSQL() string
+	InsertHistorySQL() string
+	HistorySQL(filter models.HistoryFilter) (string, []any)
 }
 
 type DBRepo struct {
 	app            *config.AppConfig
+	driverName     string
 	driver         DBDriver
 	connectionData DBConnectionData
 	db             *sql.DB
+	lockConn       *sql.Conn
 }
 
 func NewDBRepo(dbdrivername string, connData DBConnectionData, a *config.AppConfig) (*DBRepo, error) {
 	dbdrivername = strings.ToUpper(dbdrivername)
 	dbrepo := DBRepo{
-		app: a,
+		app:        a,
+		driverName: dbdrivername,
+	}
+
+	factory, ok := driverFactory(dbdrivername)
+	if !ok {
+		return nil, fmt.Errorf("ConnectToDB - %w %q. Value must be one of the following (%s)", ErrInvalidDriver, dbdrivername, strings.Join(Drivers(), ", "))
+	}
+
+	// The longest name that is derived from the migration table is the name of the version index of the history table
+	if err := validateIdentifier(connData.MigrationTable, len(HistoryTableSuffix+versionIndexSuffix)); err != nil {
+		return nil, fmt.Errorf("NewDBRepo - migration table - %w", err)
 	}
-	switch dbdrivername {
-	case DBDRIVER_POSTGRES:
-		dbrepo.driver = &PostgresDBDriver{}
-		dbrepo.connectionData = connData
-	case DBDRIVER_MYSQL:
-		dbrepo.driver = &MySQLDBDriver{}
-		dbrepo.connectionData = connData
-	default:
-		return nil, fmt.Errorf("ConnectToDB - %q is not a valid DB Driver Name. Value must be one of the following (%s)", dbdrivername, fmt.Sprintf("%s, %s", DBDRIVER_POSTGRES, DBDRIVER_MYSQL))
+
+	if err := validateIdentifier(connData.MigrationSchema, 0); err != nil {
+		return nil, fmt.Errorf("NewDBRepo - migration schema - %w", err)
 	}
 
+	dbrepo.driver = factory(connData)
+	dbrepo.connectionData = connData
+
 	return &dbrepo, nil
 }
 
+// Identity returns a string that identifies the target db and migration table without exposing the password. The
+// in-memory dbs of SQLite are told apart by the number of the db
+func (r DBRepo) Identity() string {
+	dbName := r.connectionData.DBName
+	if d, ok := r.driver.(memoryDBNamer); ok && dbName == SQLITE_MEMORY {
+		dbName = d.memoryDBName()
+	}
+
+	return fmt.Sprintf("%s://%s@%s:%s/%s/%s", strings.ToLower(r.driverName), r.connectionData.DBUser, r.connectionData.DBHost, r.connectionData.DBPort, dbName, r.qualifiedMigrationTable())
+}
+
+// qualifiedMigrationTable returns the name of the migration table qualified with the migration schema if one is set
+func (r DBRepo) qualifiedMigrationTable() string {
+	if r.connectionData.MigrationSchema == "" {
+		return MigrationTableName(r.connectionData.MigrationTable)
+	}
+
+	return r.connectionData.MigrationSchema + "." + MigrationTableName(r.connectionData.MigrationTable)
+}
+
 func (r *DBRepo) ConnectToDB() error {
+	return r.ConnectToDBContext(context.Background())
+}
+
+func (r *DBRepo) ConnectToDBContext(ctx context.Context) error {
 	myDB, err := r.driver.Open(r.connectionData)
 	if err != nil {
-		return fmt.Errorf("ConnectToDB - %s", err)
+		return fmt.Errorf("ConnectToDB - %w", err)
 	}
 
 	myDB.SetMaxOpenConns(10)
 	myDB.SetConnMaxIdleTime(5)
 	myDB.SetConnMaxLifetime(5 & time.Minute)
 
-	err = myDB.Ping()
+	err = myDB.PingContext(ctx)
 	if err != nil {
-		return fmt.Errorf("ConnectToDB - %s", err)
+		myDB.Close()
+		return fmt.Errorf("ConnectToDB - %w", err)
 	}
 
 	r.db = myDB
 	return nil
 }
 
+// CloseDB releases the migration lock if it is still held and closes the db
 func (r *DBRepo) CloseDB() error {
+	r.Unlock()
 	return r.db.Close()
 }
 
+// Close closes the db connection if it is open and releases the resources of the driver, e.g. the in-memory db of the
+// SQLite driver. The DBRepo can be connected again after it was closed
+func (r *DBRepo) Close() error {
+	var err error
+	if r.db != nil {
+		err = r.CloseDB()
+	}
+
+	if closer, ok := r.driver.(io.Closer); ok {
+		err = errors.Join(err, closer.Close())
+	}
+
+	if err != nil {
+		return fmt.Errorf("Close - %w", err)
+	}
+
+	return nil
+}
+
+// Lock acquires an exclusive cross-process migration lock so that only one migrator can change the db at a time. Lock
+// waits up to AppConfig.LockTimeout (DefaultLockTimeout if not set) for the lock to be released by its holder.
+// The lock is held on a dedicated connection until Unlock is called. SQLite has no migration lock, see SQLiteDBDriver
+func (r *DBRepo) Lock() error {
+	return r.LockContext(context.Background())
+}
+
+func (r *DBRepo) LockContext(ctx context.Context) error {
+	if r.lockConn != nil {
+		return fmt.Errorf("Lock - %w", ErrLockAcquired)
+	}
+
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("Lock - %w", err)
+	}
+
+	timeout := DefaultLockTimeout
+	if r.app != nil && r.app.LockTimeout > 0 {
+		timeout = r.app.LockTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+	stmt, args := r.driver.TryLockSQL(r.lockName())
+	for {
+		var locked bool
+		err = conn.QueryRowContext(ctx, stmt, args...).Scan(&locked)
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("Lock - %w", err)
+		}
+
+		if locked {
+			r.lockConn = conn
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			conn.Close()
+			holder, err := r.lockHolder(ctx)
+			if err != nil {
+				holder = fmt.Sprintf("unknown (%s)", err)
+			}
+
+			return fmt.Errorf("Lock - %w %q within %s. The lock is held by %s", ErrLockTimeout, r.lockName(), timeout, holder)
+		}
+
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			return fmt.Errorf("Lock - %w", ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// lockName returns the name of the migration lock. Each migration table has its own lock, so that applications that
+// use different migration tables in the same db do not wait for each other
+func (r *DBRepo) lockName() string {
+	name := lockNamePrefix + r.qualifiedMigrationTable()
+
+	// MySQL limits lock names to 64 characters
+	if len(name) > maxLockNameLength {
+		sum := sha256.Sum256([]byte(name))
+		name = lockNamePrefix + hex.EncodeToString(sum[:16])
+	}
+
+	return name
+}
+
+// lockHolder describes the session that holds the migration lock
+func (r *DBRepo) lockHolder(ctx context.Context) (string, error) {
+	var holder string
+	stmt, args := r.driver.LockHolderSQL(r.lockName())
+	err := r.db.QueryRowContext(ctx, stmt, args...).Scan(&holder)
+	if err != nil {
+		return "", err
+	}
+
+	if holder == "" {
+		return "an unknown session", nil
+	}
+
+	return holder, nil
+}
+
+// Unlock releases the migration lock acquired by Lock
+func (r *DBRepo) Unlock() error {
+	return r.UnlockContext(context.Background())
+}
+
+func (r *DBRepo) UnlockContext(ctx context.Context) error {
+	if r.lockConn == nil {
+		return nil
+	}
+
+	defer func() {
+		r.lockConn.Close()
+		r.lockConn = nil
+	}()
+
+	var released any
+	stmt, args := r.driver.UnlockSQL(r.lockName())
+	err := r.lockConn.QueryRowContext(ctx, stmt, args...).Scan(&released)
+	if err != nil {
+		return fmt.Errorf("Unlock - %w", err)
+	}
+
+	return nil
+}
+
+// MigrationTableColumnDefinition returns the definition of column, one of the columns that SetupMigrationTable adds to
+// existing migration tables. Drivers use it to build the statement of AddMigrationTableColumnSQL
+func MigrationTableColumnDefinition(column string) (string, error) {
+	definition, ok := migrationTableColumnDefinitions[column]
+	if !ok {
+		return "", fmt.Errorf("AddMigrationTableColumnSQL - %q is not a migration table column", column)
+	}
+
+	return definition, nil
+}
+
 func (r DBRepo) SetupMigrationTable() error {
-	_, err := r.db.Exec(r.driver.SetupMigrationTableSQL())
+	return r.SetupMigrationTableContext(context.Background())
+}
+
+// SetupMigrationTableContext creates the migration table if it does not exist and upgrades an existing migration table
+// by adding the columns that are missing
+func (r DBRepo) SetupMigrationTableContext(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, r.driver.SetupMigrationTableSQL())
 	if err != nil {
-		return fmt.Errorf("SetupMigrationTable - %s", err)
+		return fmt.Errorf("SetupMigrationTable - %w", err)
+	}
+
+	stmt, args := r.driver.MigrationTableColumnsSQL()
+	rows, err := r.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return fmt.Errorf("SetupMigrationTable - %w", err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return fmt.Errorf("SetupMigrationTable - %w", err)
+		}
+
+		columns[strings.ToLower(column)] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("SetupMigrationTable - %w", err)
+	}
+
+	for _, column := range migrationTableUpgrades {
+		if columns[column] {
+			continue
+		}
+
+		stmt, err := r.driver.AddMigrationTableColumnSQL(column)
+		if err != nil {
+			return fmt.Errorf("SetupMigrationTable - %w", err)
+		}
+
+		if stmt == "" {
+			continue
+		}
+
+		_, err = r.db.ExecContext(ctx, stmt)
+		if err != nil {
+			return fmt.Errorf("SetupMigrationTable - adding column %s - %w", column, err)
+		}
+	}
+
+	_, err = r.db.ExecContext(ctx, r.driver.SetupHistoryTableSQL())
+	if err != nil {
+		return fmt.Errorf("SetupMigrationTable - history table - %w", err)
 	}
 
 	return nil
 }
 
-func (r DBRepo) MigrateDB(toVersion, migrationDirection string) error {
+func (r DBRepo) MigrateDB(rec models.MigrationRecord, migrationDirection string) error {
+	return r.MigrateDBContext(context.Background(), rec, migrationDirection)
+}
+
+func (r DBRepo) MigrateDBContext(ctx context.Context, rec models.MigrationRecord, migrationDirection string) error {
 	stmt, err := r.driver.MigrateDBSQL(migrationDirection)
 	if err != nil {
-		return fmt.Errorf("migrateDB - %s", err)
+		return fmt.Errorf("migrateDB - %w", err)
 	}
 
-	_, err = r.db.Exec(stmt, toVersion)
+	_, err = r.db.ExecContext(ctx, stmt, migrateDBArgs(rec, migrationDirection)...)
 	if err != nil {
-		return fmt.Errorf("migrateDB - %s", err)
+		return fmt.Errorf("migrateDB - %w", err)
 	}
 
 	return nil
 }
 
-func (r DBRepo) MigrateData(toVersion, script, migrationDirection string) error {
+// migrateDBArgs returns the arguments of the statement returned by DBDriver.MigrateDBSQL. Only the version is required
+// to remove a version, while all the recorded details are required to add a version
+func migrateDBArgs(rec models.MigrationRecord, migrationDirection string) []any {
+	if strings.ToLower(migrationDirection) == "up" {
+		return []any{rec.Version, rec.Checksum, rec.Description, rec.Duration.Milliseconds(), rec.OSUser, rec.Hostname, rec.ToolVersion}
+	}
+
+	return []any{rec.Version}
+}
+
+// clearDirtyArgs returns the arguments of the statement returned by DBDriver.ClearDirtySQL
+func clearDirtyArgs(rec models.MigrationRecord, migrationDirection string) []any {
+	if strings.ToLower(migrationDirection) == "up" {
+		return []any{rec.Duration.Milliseconds(), rec.Version}
+	}
+
+	return []any{rec.Version}
+}
+
+// MigrateDBStatement returns the statement that is used to record a migration version in the migration table
+func (r DBRepo) MigrateDBStatement(migrationDirection string) (string, error) {
+	stmt, err := r.driver.MigrateDBSQL(migrationDirection)
+	if err != nil {
+		return "", fmt.Errorf("MigrateDBStatement - %w", err)
+	}
+
+	return stmt, nil
+}
+
+// MigrateData runs a migration script and records the migration version. See migrate for the transaction semantics.
+// The script is split into statements with the rules of the driver's SQL dialect and the statements are run one by one.
+// Scripts with the no-transaction directive are run without a transaction and the migration version is marked dirty
+// until the script succeeded
+func (r DBRepo) MigrateData(rec models.MigrationRecord, script models.MigrationScript, migrationDirection string) error {
+	return r.MigrateDataContext(context.Background(), rec, script, migrationDirection)
+}
+
+func (r DBRepo) MigrateDataContext(ctx context.Context, rec models.MigrationRecord, script models.MigrationScript, migrationDirection string) error {
 	migrationDirection = strings.ToLower(migrationDirection)
 
-	tx, err := r.db.Begin()
+	stmts, err := r.driver.SplitStatements(script.SQL)
 	if err != nil {
-		return fmt.Errorf("migrateData - %s", err)
+		return fmt.Errorf("migrateData - version %s - %w", rec.Version, err)
+	}
+
+	if script.NoTransaction {
+		return r.migrateDataWithoutTx(ctx, rec, script, stmts, migrationDirection)
+	}
+
+	return r.migrate(ctx, "migrateData", rec, migrationDirection, func(tx *sql.Tx) error {
+		return r.execStatements(ctx, tx, rec, script, stmts)
+	})
+}
+
+// SplitStatements splits a migration script into the statements that will be run one by one
+func (r DBRepo) SplitStatements(script string) ([]Statement, error) {
+	return r.driver.SplitStatements(script)
+}
+
+// execStatements runs stmts, the statements of script, one by one. A *MigrationError that identifies the statement that
+// failed and the position of the error in the migration file is returned if a statement fails
+func (r DBRepo) execStatements(ctx context.Context, e execer, rec models.MigrationRecord, script models.MigrationScript, stmts []Statement) error {
+	for i, stmt := range stmts {
+		_, err := e.ExecContext(ctx, stmt.SQL)
+		if err != nil {
+			return newMigrationError(rec.Version, script, i, stmt, r.driver.ErrorOffset(stmt.SQL, err), err)
+		}
+	}
+
+	return nil
+}
+
+// migrateDataWithoutTx runs a script that may not be run in a transaction, e.g. CREATE INDEX CONCURRENTLY on
+// Postgres. The migration version is marked dirty before the script is run and the mark is cleared once it succeeded.
+// All statements run on the same connection, so that session state (e.g. SET search_path or temp tables) set by one
+// statement is kept for the next
+func (r DBRepo) migrateDataWithoutTx(ctx context.Context, rec models.MigrationRecord, script models.MigrationScript, stmts []Statement, migrationDirection string) error {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("migrateData - version %s - %w", rec.Version, err)
+	}
+	defer conn.Close()
+
+	err = r.markDirty(ctx, rec, migrationDirection)
+	if err != nil {
+		return fmt.Errorf("migrateData - version %s - %w", rec.Version, err)
+	}
+
+	start := time.Now()
+	err = r.execStatements(ctx, conn, rec, script, stmts)
+	if err != nil {
+		return &DirtyError{Version: rec.Version, Err: fmt.Errorf("migrateData - version %s - %w", rec.Version, err)}
+	}
+	rec.Duration = time.Since(start)
+
+	err = r.clearDirty(ctx, r.db, rec, migrationDirection)
+	if err != nil {
+		return &DirtyError{Version: rec.Version, Err: fmt.Errorf("migrateData - version %s - script was applied but the version could not be recorded - %w", rec.Version, err)}
+	}
+
+	return nil
+}
+
+// MigrateFunc runs a Go migration function and records the migration version. See migrate for the transaction semantics
+func (r DBRepo) MigrateFunc(rec models.MigrationRecord, fn models.MigrationFunc, migrationDirection string) error {
+	return r.MigrateFuncContext(context.Background(), rec, fn, migrationDirection)
+}
+
+func (r DBRepo) MigrateFuncContext(ctx context.Context, rec models.MigrationRecord, fn models.MigrationFunc, migrationDirection string) error {
+	return r.migrate(ctx, "migrateFunc", rec, strings.ToLower(migrationDirection), fn)
+}
+
+// migrate runs fn and records the migration version in the same transaction.
+//
+// Drivers without transactional DDL (e.g. MySQL) implicitly commit DDL statements, so a failed migration may leave the
+// db partially migrated. For these drivers the migration version is marked dirty before fn is run and the mark is only
+// cleared once fn succeeded. A *DirtyError is returned if fn fails and the db must be repaired manually before the
+// dirty mark is cleared with Recover
+func (r DBRepo) migrate(ctx context.Context, funcPrefix string, rec models.MigrationRecord, migrationDirection string, fn func(tx *sql.Tx) error) error {
+	tracked := !r.driver.SupportsTransactionalDDL()
+	if tracked {
+		err := r.markDirty(ctx, rec, migrationDirection)
+		if err != nil {
+			return fmt.Errorf(funcPrefix+" - version %s - %w", rec.Version, err)
+		}
+	}
+
+	stmt, err := r.driver.MigrateDBSQL(migrationDirection)
+	if err != nil {
+		return fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return r.dirtyError(rec.Version, fmt.Errorf(funcPrefix+" - %w", err))
 	}
 	defer tx.Rollback()
 
-	_, err = r.db.Exec(script)
+	start := time.Now()
+	err = fn(tx)
 	if err != nil {
-		return fmt.Errorf("migrateData - version %s - %s", toVersion, err)
+		return r.dirtyError(rec.Version, fmt.Errorf(funcPrefix+" - version %s - %w", rec.Version, err))
 	}
+	rec.Duration = time.Since(start)
 
-	err = r.MigrateDB(toVersion, migrationDirection)
+	if tracked {
+		err = r.clearDirty(ctx, tx, rec, migrationDirection)
+	} else {
+		_, err = tx.ExecContext(ctx, stmt, migrateDBArgs(rec, migrationDirection)...)
+	}
 	if err != nil {
-		return fmt.Errorf("migrateData - version %s - Admin script - %s", toVersion, err)
+		return r.dirtyError(rec.Version, fmt.Errorf(funcPrefix+" - version %s - Admin script - %w", rec.Version, err))
 	}
 
 	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("migrateData - Commit - %s", err)
+		return r.dirtyError(rec.Version, fmt.Errorf(funcPrefix+" - Commit - %w", err))
+	}
+
+	return nil
+}
+
+// markDirty records that a migration of rec in migrationDirection has started
+func (r DBRepo) markDirty(ctx context.Context, rec models.MigrationRecord, migrationDirection string) error {
+	stmt, err := r.driver.MarkDirtySQL(migrationDirection)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, stmt, migrateDBArgs(rec, migrationDirection)...)
+	return err
+}
+
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// clearDirty records that the dirty migration of rec in migrationDirection has completed. An up migration is marked
+// as applied and the row of a down migration is removed
+func (r DBRepo) clearDirty(ctx context.Context, e execer, rec models.MigrationRecord, migrationDirection string) error {
+	stmt, err := r.driver.ClearDirtySQL(migrationDirection)
+	if err != nil {
+		return err
+	}
+
+	res, err := e.ExecContext(ctx, stmt, clearDirtyArgs(rec, migrationDirection)...)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%w - %s", ErrNotDirty, rec.Version)
+	}
+
+	return nil
+}
+
+// dirtyError wraps err in a *DirtyError if the driver does not support transactional DDL, because statements that were
+// run before the failure may have been committed
+func (r DBRepo) dirtyError(version string, err error) error {
+	if r.driver.SupportsTransactionalDDL() {
+		return err
+	}
+
+	return &DirtyError{Version: version, Err: err}
+}
+
+// Recover clears the dirty mark of version after a partially applied migration was repaired manually. Direction up
+// marks the version as applied and direction down marks it as rolled back by removing it from the migration table
+func (r DBRepo) Recover(version, migrationDirection string) error {
+	return r.RecoverContext(context.Background(), version, migrationDirection)
+}
+
+func (r DBRepo) RecoverContext(ctx context.Context, version, migrationDirection string) error {
+	err := r.clearDirty(ctx, r.db, models.MigrationRecord{Version: version}, strings.ToLower(migrationDirection))
+	if err != nil {
+		return fmt.Errorf("Recover - %w", err)
 	}
 
 	return nil
 }
 
 func (r DBRepo) CurrentVersion() (string, error) {
+	return r.CurrentVersionContext(context.Background())
+}
+
+func (r DBRepo) CurrentVersionContext(ctx context.Context) (string, error) {
 	var version string
-	row := r.db.QueryRow(r.driver.CurrentVersionSQL())
+	row := r.db.QueryRowContext(ctx, r.driver.CurrentVersionSQL())
 	if row.Err() == sql.ErrNoRows {
 		return "", nil
 	}
 
 	err := row.Scan(&version)
 	if err != nil {
-		return "", fmt.Errorf("CurrentVersion - %s", err)
+		return "", fmt.Errorf("CurrentVersion - %w", err)
 	}
 
 	return version, nil
 }
 
 func (r DBRepo) MigratedVersions() ([]string, error) {
+	return r.MigratedVersionsContext(context.Background())
+}
+
+func (r DBRepo) MigratedVersionsContext(ctx context.Context) ([]string, error) {
 	var result []string
-	rows, err := r.db.Query(r.driver.MigratedVersionsSQL())
+	rows, err := r.db.QueryContext(ctx, r.driver.MigratedVersionsSQL())
 	if err != nil {
-		return result, fmt.Errorf("MigratedVersions - %s", err)
+		return result, fmt.Errorf("MigratedVersions - %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var version string
 		if err := rows.Scan(&version); err != nil {
-			return result, fmt.Errorf("MigratedVersions - %s", err)
+			return result, fmt.Errorf("MigratedVersions - %w", err)
 		}
 
 		result = append(result, version)
 	}
 
-	return result, nil
+	return result, rows.Err()
+}
+
+func (r DBRepo) AppliedMigrations() ([]models.AppliedMigration, error) {
+	return r.AppliedMigrationsContext(context.Background())
+}
+
+func (r DBRepo) AppliedMigrationsContext(ctx context.Context) ([]models.AppliedMigration, error) {
+	var result []models.AppliedMigration
+	rows, err := r.db.QueryContext(ctx, r.driver.AppliedMigrationsSQL())
+	if err != nil {
+		return result, fmt.Errorf("AppliedMigrations - %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var am models.AppliedMigration
+		var durationMs int64
+		if err := rows.Scan(&am.Version, &am.CreatedOn, &am.Checksum, &am.Dirty, &am.Description, &durationMs, &am.DBUser, &am.OSUser, &am.Hostname, &am.ToolVersion); err != nil {
+			return result, fmt.Errorf("AppliedMigrations - %w", err)
+		}
+		am.Duration = time.Duration(durationMs) * time.Millisecond
+
+		result = append(result, am)
+	}
+
+	return result, rows.Err()
+}
+
+// RecordHistory adds entry to the migration history table. Rows are never updated or deleted, so the history table
+// keeps a record of migrations that were reverted
+func (r DBRepo) RecordHistory(entry models.HistoryEntry) error {
+	return r.RecordHistoryContext(context.Background(), entry)
+}
+
+func (r DBRepo) RecordHistoryContext(ctx context.Context, entry models.HistoryEntry) error {
+	_, err := r.db.ExecContext(ctx, r.driver.InsertHistorySQL(), entry.Version, entry.Direction, entry.Command, entry.Outcome, entry.Error, entry.Duration.Milliseconds(), entry.OSUser, entry.Hostname, entry.ToolVersion)
+	if err != nil {
+		return fmt.Errorf("RecordHistory - %w", err)
+	}
+
+	return nil
+}
+
+// History returns the entries of the migration history table that match filter, oldest first
+func (r DBRepo) History(filter models.HistoryFilter) ([]models.HistoryEntry, error) {
+	return r.HistoryContext(context.Background(), filter)
+}
+
+func (r DBRepo) HistoryContext(ctx context.Context, filter models.HistoryFilter) ([]models.HistoryEntry, error) {
+	var result []models.HistoryEntry
+	stmt, args := r.driver.HistorySQL(filter)
+	rows, err := r.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return result, fmt.Errorf("History - %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry models.HistoryEntry
+		var durationMs int64
+		if err := rows.Scan(&entry.ID, &entry.Version, &entry.Direction, &entry.Command, &entry.Outcome, &entry.Error, &durationMs, &entry.DBUser, &entry.OSUser, &entry.Hostname, &entry.ToolVersion, &entry.CreatedOn); err != nil {
+			return result, fmt.Errorf("History - %w", err)
+		}
+		entry.Duration = time.Duration(durationMs) * time.Millisecond
+
+		result = append(result, entry)
+	}
+
+	return result, rows.Err()
+}
+
+// MigrationTableExists reports whether the migration table has been created
+func (r DBRepo) MigrationTableExists() (bool, error) {
+	return r.MigrationTableExistsContext(context.Background())
+}
+
+func (r DBRepo) MigrationTableExistsContext(ctx context.Context) (bool, error) {
+	var exists bool
+	stmt, args := r.driver.MigrationTableExistsSQL()
+	err := r.db.QueryRowContext(ctx, stmt, args...).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("MigrationTableExists - %w", err)
+	}
+
+	return exists, nil
 }
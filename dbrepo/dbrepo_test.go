@@ -0,0 +1,670 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhanekom/dbmigrator/config"
+	"github.com/dhanekom/dbmigrator/models"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+)
+
+// fakeDB is an in-memory database/sql driver that records which statements were committed. Statements run in a
+// transaction are only committed when the transaction commits, except DDL statements when autoCommitDDL is set, which
+// mimics MySQL's implicit commit of DDL. The migration lock is held by lockHolder if it is set. execConns records the
+// number of the connection that ran each statement
+type fakeDB struct {
+	mu            sync.Mutex
+	autoCommitDDL bool
+	failOn        string
+	notDirty      bool
+	err           error
+	lockHolder    string
+	committed     []string
+	conns         int
+	execConns     map[string]int
+}
+
+func (f *fakeDB) Connect(ctx context.Context) (driver.Conn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.conns++
+	return &fakeConn{db: f, id: f.conns}, nil
+}
+
+func (f *fakeDB) Driver() driver.Driver {
+	return nil
+}
+
+func (f *fakeDB) commit(stmts ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.committed = append(f.committed, stmts...)
+}
+
+type fakeConn struct {
+	db      *fakeDB
+	id      int
+	pending []string
+	inTx    bool
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.inTx = true
+	return c, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	if c.db.execConns == nil {
+		c.db.execConns = make(map[string]int)
+	}
+	c.db.execConns[query] = c.id
+	c.db.mu.Unlock()
+
+	if c.db.failOn != "" && strings.Contains(query, c.db.failOn) {
+		if c.db.err != nil {
+			return nil, c.db.err
+		}
+
+		return nil, errors.New("exec failed")
+	}
+
+	var rowsAffected driver.RowsAffected = 1
+	if c.db.notDirty && strings.HasSuffix(query, "and dirty") {
+		rowsAffected = 0
+	}
+
+	isDDL := strings.HasPrefix(strings.ToLower(strings.TrimSpace(query)), "create")
+	if !c.inTx || (isDDL && c.db.autoCommitDDL) {
+		c.db.commit(query)
+		return rowsAffected, nil
+	}
+
+	c.pending = append(c.pending, query)
+	return rowsAffected, nil
+}
+
+// QueryContext answers the queries of the Postgres migration lock
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	switch {
+	case strings.Contains(query, "pg_try_advisory_lock"):
+		return &fakeRows{values: []driver.Value{c.db.lockHolder == ""}}, nil
+	case strings.Contains(query, "pg_advisory_unlock"):
+		return &fakeRows{values: []driver.Value{true}}, nil
+	case strings.Contains(query, "pg_locks"):
+		return &fakeRows{values: []driver.Value{c.db.lockHolder}}, nil
+	default:
+		return nil, errors.New("query not supported")
+	}
+}
+
+// fakeRows returns a single row with values
+type fakeRows struct {
+	values []driver.Value
+	done   bool
+}
+
+func (r *fakeRows) Columns() []string {
+	return make([]string, len(r.values))
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+
+	r.done = true
+	copy(dest, r.values)
+	return nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.db.commit(c.pending...)
+	c.pending, c.inTx = nil, false
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.pending, c.inTx = nil, false
+	return nil
+}
+
+// fakeDBDriver is a PostgresDBDriver with configurable transactional DDL support
+type fakeDBDriver struct {
+	PostgresDBDriver
+	transactionalDDL bool
+}
+
+func (d *fakeDBDriver) SupportsTransactionalDDL() bool {
+	return d.transactionalDDL
+}
+
+func TestDBRepo_MigrateData(t *testing.T) {
+	const script = "create table a (id int)"
+	tests := []struct {
+		name             string
+		transactionalDDL bool
+		noTransaction    bool
+		failOn           string
+		wantCommitted    int
+		wantErr          bool
+		wantDirty        bool
+	}{
+		{name: "transactional ddl success", transactionalDDL: true, wantCommitted: 2},
+		{name: "transactional ddl bookkeeping fails", transactionalDDL: true, failOn: "schema_migration", wantCommitted: 0, wantErr: true},
+		{name: "transactional ddl script fails", transactionalDDL: true, failOn: "create table", wantCommitted: 0, wantErr: true},
+		{name: "non transactional ddl success", wantCommitted: 3},
+		{name: "non transactional ddl mark dirty fails", failOn: "dirty) values", wantCommitted: 0, wantErr: true},
+		{name: "non transactional ddl script fails", failOn: "create table", wantCommitted: 1, wantErr: true, wantDirty: true},
+		{name: "non transactional ddl clear dirty fails", failOn: "set dirty = false", wantCommitted: 2, wantErr: true, wantDirty: true},
+		{name: "no transaction directive success", transactionalDDL: true, noTransaction: true, wantCommitted: 3},
+		{name: "no transaction directive clear dirty fails", transactionalDDL: true, noTransaction: true, failOn: "set dirty = false", wantCommitted: 2, wantErr: true, wantDirty: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeDB{autoCommitDDL: !tt.transactionalDDL, failOn: tt.failOn}
+			r := DBRepo{
+				driver: &fakeDBDriver{transactionalDDL: tt.transactionalDDL},
+				db:     sql.OpenDB(fake),
+			}
+			defer r.db.Close()
+
+			rec := models.MigrationRecord{Version: "20230101_000000", Checksum: "abc"}
+			err := r.MigrateData(rec, models.MigrationScript{SQL: script, NoTransaction: tt.noTransaction}, "up")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DBRepo.MigrateData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var dirtyErr *DirtyError
+			if errors.As(err, &dirtyErr) != tt.wantDirty {
+				t.Errorf("DBRepo.MigrateData() error = %v, wantDirty %v", err, tt.wantDirty)
+			}
+
+			if len(fake.committed) != tt.wantCommitted {
+				t.Errorf("DBRepo.MigrateData() committed %v, want %d statements", fake.committed, tt.wantCommitted)
+			}
+		})
+	}
+}
+
+func TestDBRepo_MigrateDataWithoutTxConnection(t *testing.T) {
+	fake := &fakeDB{}
+	r := DBRepo{
+		driver: &fakeDBDriver{transactionalDDL: true},
+		db:     sql.OpenDB(fake),
+	}
+	defer r.db.Close()
+
+	// Without idle connections every statement that is run on the pool gets a new connection
+	r.db.SetMaxIdleConns(0)
+
+	script := models.MigrationScript{SQL: "set lock_timeout = '5s';\ncreate temp table t (id int);\ncreate index concurrently a_idx on a (id)", NoTransaction: true}
+	err := r.MigrateData(models.MigrationRecord{Version: "20230101_000000"}, script, "up")
+	if err != nil {
+		t.Fatalf("DBRepo.MigrateData() error = %v", err)
+	}
+
+	stmts, err := r.SplitStatements(script.SQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := fake.execConns[stmts[0].SQL]
+	for _, stmt := range stmts {
+		if fake.execConns[stmt.SQL] != conn {
+			t.Errorf("DBRepo.MigrateData() ran the statements on connections %v, want a single connection", fake.execConns)
+			break
+		}
+	}
+}
+
+func TestDBRepo_Recover(t *testing.T) {
+	tests := []struct {
+		name      string
+		direction string
+		notDirty  bool
+		want      string
+		wantErr   bool
+	}{
+		{name: "mark applied", direction: "up", want: `update "public"."schema_migration" set dirty = false, duration_ms = greatest(duration_ms, $1) where version = $2 and dirty`},
+		{name: "mark rolled back", direction: "down", want: `delete from "public"."schema_migration" where version = $1 and dirty`},
+		{name: "version not dirty", direction: "up", notDirty: true, wantErr: true},
+		{name: "invalid direction", direction: "sideways", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeDB{notDirty: tt.notDirty}
+			r := DBRepo{
+				driver: &fakeDBDriver{transactionalDDL: true},
+				db:     sql.OpenDB(fake),
+			}
+			defer r.db.Close()
+
+			err := r.Recover("20230101_000000", tt.direction)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DBRepo.Recover() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.want != "" && (len(fake.committed) != 1 || fake.committed[0] != tt.want) {
+				t.Errorf("DBRepo.Recover() committed %v, want %q", fake.committed, tt.want)
+			}
+		})
+	}
+}
+
+func TestDBRepo_MigrateDataStatements(t *testing.T) {
+	script := models.MigrationScript{
+		Filename: "20230101_000000_a.up.sql",
+		SQL:      "insert into a values (1);\n\ncreate table b (\n\tid intt\n);\ninsert into c values (3);",
+	}
+	tests := []struct {
+		name        string
+		err         error
+		template    bool
+		wantLine    int
+		wantColumn  int
+		wantExcerpt string
+		wantError   string
+	}{
+		{
+			name:        "position reported",
+			err:         &pgconn.PgError{Message: `type "intt" does not exist`, Position: 22},
+			wantLine:    4,
+			wantColumn:  5,
+			wantExcerpt: "  2 | \n  3 | create table b (\n> 4 | \tid intt\n    | \t   ^\n  5 | );\n  6 | insert into c values (3);",
+			wantError:   "20230101_000000_a.up.sql statement 2 at line 4, column 5 - ",
+		},
+		{
+			name:        "position unknown",
+			err:         errors.New("exec failed"),
+			wantLine:    3,
+			wantExcerpt: "  1 | insert into a values (1);\n  2 | \n> 3 | create table b (\n  4 | \tid intt\n  5 | );",
+			wantError:   "20230101_000000_a.up.sql statement 2 at line 3 - exec failed",
+		},
+		{
+			name:        "template",
+			err:         &pgconn.PgError{Message: `type "intt" does not exist`, Position: 22},
+			template:    true,
+			wantLine:    4,
+			wantColumn:  5,
+			wantExcerpt: "  2 | \n  3 | create table b (\n> 4 | \tid intt\n    | \t   ^\n  5 | );\n  6 | insert into c values (3);",
+			wantError:   "20230101_000000_a.up.sql statement 2 at line 4, column 5 of the rendered SQL - ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeDB{failOn: "create table b", err: tt.err}
+			r := DBRepo{
+				driver: &fakeDBDriver{transactionalDDL: true},
+				db:     sql.OpenDB(fake),
+			}
+			defer r.db.Close()
+
+			script := script
+			script.Template = tt.template
+			err := r.MigrateData(models.MigrationRecord{Version: "20230101_000000"}, script, "up")
+
+			var migErr *MigrationError
+			if !errors.As(err, &migErr) {
+				t.Fatalf("DBRepo.MigrateData() error = %v, want a *MigrationError", err)
+			}
+
+			if migErr.Filename != script.Filename || migErr.Statement != 2 || migErr.Line != tt.wantLine || migErr.Column != tt.wantColumn {
+				t.Errorf("DBRepo.MigrateData() error at %s statement %d line %d column %d, want %s statement 2 line %d column %d", migErr.Filename, migErr.Statement, migErr.Line, migErr.Column, script.Filename, tt.wantLine, tt.wantColumn)
+			}
+
+			if migErr.Excerpt != tt.wantExcerpt {
+				t.Errorf("DBRepo.MigrateData() excerpt =\n%s\nwant\n%s", migErr.Excerpt, tt.wantExcerpt)
+			}
+
+			if migErr.Rendered != tt.template || !strings.Contains(migErr.Error(), tt.wantError) {
+				t.Errorf("MigrationError.Error() = %q, want %q", migErr.Error(), tt.wantError)
+			}
+
+			if !errors.Is(err, tt.err) {
+				t.Errorf("DBRepo.MigrateData() error must wrap the driver error")
+			}
+		})
+	}
+}
+
+func TestMySQLDBDriver_ErrorOffset(t *testing.T) {
+	stmt := "create table b (\n  id intt\n)"
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "syntax error", err: &mysql.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax; check the manual that corresponds to your MySQL server version for the right syntax to use near 'intt\n)' at line 2"}, want: 22},
+		{name: "end of statement", err: &mysql.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax; check the manual that corresponds to your MySQL server version for the right syntax to use near '' at line 3"}, want: len(stmt)},
+		{name: "other error", err: &mysql.MySQLError{Number: 1050, Message: "Table 'b' already exists"}, want: -1},
+		{name: "not a mysql error", err: errors.New("exec failed"), want: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &MySQLDBDriver{}
+			if got := d.ErrorOffset(stmt, tt.err); got != tt.want {
+				t.Errorf("MySQLDBDriver.ErrorOffset() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDBDriver_AddMigrationTableColumnSQL(t *testing.T) {
+	drivers := map[string]DBDriver{DBDRIVER_POSTGRES: &PostgresDBDriver{}, DBDRIVER_MYSQL: &MySQLDBDriver{}, DBDRIVER_SQLITE: &SQLiteDBDriver{}}
+	for name, d := range drivers {
+		for _, column := range migrationTableUpgrades {
+			stmt, err := d.AddMigrationTableColumnSQL(column)
+			if name == DBDRIVER_SQLITE && column == "db_user" {
+				if err != nil || stmt != "" {
+					t.Errorf("%s AddMigrationTableColumnSQL(%q) = %q, %v, want the column to be skipped", name, column, stmt, err)
+				}
+				continue
+			}
+
+			if err != nil || !strings.Contains(stmt, "ADD COLUMN "+column+" ") {
+				t.Errorf("%s AddMigrationTableColumnSQL(%q) = %q, %v", name, column, stmt, err)
+			}
+		}
+
+		if _, err := d.AddMigrationTableColumnSQL("unknown"); err == nil {
+			t.Errorf("%s AddMigrationTableColumnSQL() must fail for an unknown column", name)
+		}
+	}
+}
+
+// bindArgs replaces the placeholders of stmt ($1 or ?) with the values of args, so that tests can check that the
+// arguments are passed in the order of the placeholders
+func bindArgs(stmt string, args []any) string {
+	for i := len(args); i > 0; i-- {
+		stmt = strings.ReplaceAll(stmt, fmt.Sprintf("$%d", i), fmt.Sprintf("%#v", args[i-1]))
+	}
+
+	for _, arg := range args {
+		stmt = strings.Replace(stmt, "?", fmt.Sprintf("%#v", arg), 1)
+	}
+
+	return stmt
+}
+
+func TestDBDriver_ClearDirtySQL(t *testing.T) {
+	rec := models.MigrationRecord{Version: "20230101_000000", Duration: 1500 * time.Millisecond}
+	tests := []struct {
+		name      string
+		driver    DBDriver
+		direction string
+		want      string
+	}{
+		{name: "postgres up", driver: &PostgresDBDriver{}, direction: "up", want: `update "public"."schema_migration" set dirty = false, duration_ms = greatest(duration_ms, 1500) where version = "20230101_000000" and dirty`},
+		{name: "postgres down", driver: &PostgresDBDriver{}, direction: "down", want: `delete from "public"."schema_migration" where version = "20230101_000000" and dirty`},
+		{name: "mysql up", driver: &MySQLDBDriver{}, direction: "up", want: "update `schema_migration` set dirty = false, duration_ms = greatest(duration_ms, 1500) where version = \"20230101_000000\" and dirty"},
+		{name: "mysql down", driver: &MySQLDBDriver{}, direction: "down", want: "delete from `schema_migration` where version = \"20230101_000000\" and dirty"},
+		{name: "sqlite up", driver: &SQLiteDBDriver{}, direction: "up", want: `update "schema_migration" set dirty = false, duration_ms = max(duration_ms, 1500) where version = "20230101_000000" and dirty`},
+		{name: "sqlite down", driver: &SQLiteDBDriver{}, direction: "down", want: `delete from "schema_migration" where version = "20230101_000000" and dirty`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := tt.driver.ClearDirtySQL(tt.direction)
+			if err != nil {
+				t.Fatalf("ClearDirtySQL() error = %v", err)
+			}
+
+			if got := bindArgs(stmt, clearDirtyArgs(rec, tt.direction)); got != tt.want {
+				t.Errorf("ClearDirtySQL() with clearDirtyArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDBDriver_HistorySQL(t *testing.T) {
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		driver   DBDriver
+		filter   models.HistoryFilter
+		wantCond string
+		wantArgs int
+	}{
+		{name: "postgres no filter", driver: &PostgresDBDriver{}, wantCond: `from "public"."schema_migration_history" order by id`},
+		{name: "postgres all filters", driver: &PostgresDBDriver{}, filter: models.HistoryFilter{Version: "20230101_000000", From: from, To: to}, wantCond: "where version = $1 and created_on >= $2 and created_on <= $3 order by id", wantArgs: 3},
+		{name: "postgres date range", driver: &PostgresDBDriver{}, filter: models.HistoryFilter{From: from}, wantCond: "where created_on >= $1 order by id", wantArgs: 1},
+		{name: "mysql all filters", driver: &MySQLDBDriver{}, filter: models.HistoryFilter{Version: "20230101_000000", From: from, To: to}, wantCond: "where version = ? and created_on >= ? and created_on <= ? order by id", wantArgs: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, args := tt.driver.HistorySQL(tt.filter)
+			if !strings.HasSuffix(stmt, tt.wantCond) || len(args) != tt.wantArgs {
+				t.Errorf("HistorySQL() = %q, %v, want suffix %q and %d args", stmt, args, tt.wantCond, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestDBDriver_MigrationTable(t *testing.T) {
+	tests := []struct {
+		name        string
+		driver      DBDriver
+		wantTable   string
+		wantHistory string
+		wantArgs    []any
+	}{
+		{name: "postgres default", driver: &PostgresDBDriver{}, wantTable: `"public"."schema_migration"`, wantHistory: `"public"."schema_migration_history"`, wantArgs: []any{"public", "schema_migration"}},
+		{name: "postgres configured", driver: &PostgresDBDriver{schema: "ops", table: "billing_migration"}, wantTable: `"ops"."billing_migration"`, wantHistory: `"ops"."billing_migration_history"`, wantArgs: []any{"ops", "billing_migration"}},
+		{name: "postgres quoted", driver: &PostgresDBDriver{schema: "Ops", table: `a"b`}, wantTable: `"Ops"."a""b"`, wantHistory: `"Ops"."a""b_history"`, wantArgs: []any{"Ops", `a"b`}},
+		{name: "mysql default", driver: &MySQLDBDriver{}, wantTable: "`schema_migration`", wantHistory: "`schema_migration_history`", wantArgs: []any{"schema_migration"}},
+		{name: "mysql configured", driver: &MySQLDBDriver{schema: "ops", table: "billing_migration"}, wantTable: "`ops`.`billing_migration`", wantHistory: "`ops`.`billing_migration_history`", wantArgs: []any{"ops", "billing_migration"}},
+		{name: "mysql quoted", driver: &MySQLDBDriver{table: "a`b"}, wantTable: "`a``b`", wantHistory: "`a``b_history`", wantArgs: []any{"a`b"}},
+		{name: "sqlite default", driver: &SQLiteDBDriver{}, wantTable: `"schema_migration"`, wantHistory: `"schema_migration_history"`, wantArgs: []any{"schema_migration"}},
+		{name: "sqlite quoted", driver: &SQLiteDBDriver{table: `a"b`}, wantTable: `"a""b"`, wantHistory: `"a""b_history"`, wantArgs: []any{`a"b`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if stmt := tt.driver.CurrentVersionSQL(); !strings.HasSuffix(stmt, " from "+tt.wantTable) {
+				t.Errorf("CurrentVersionSQL() = %q, want table %s", stmt, tt.wantTable)
+			}
+
+			stmt, err := tt.driver.MigrateDBSQL("up")
+			if err != nil || !strings.HasPrefix(stmt, "insert into "+tt.wantTable+" (") {
+				t.Errorf("MigrateDBSQL() = %q, %v, want table %s", stmt, err, tt.wantTable)
+			}
+
+			if stmt := tt.driver.SetupMigrationTableSQL(); !strings.Contains(stmt, "CREATE TABLE IF NOT EXISTS "+tt.wantTable+" (") {
+				t.Errorf("SetupMigrationTableSQL() = %q, want table %s", stmt, tt.wantTable)
+			}
+
+			if stmt := tt.driver.InsertHistorySQL(); !strings.HasPrefix(stmt, "insert into "+tt.wantHistory+" (") {
+				t.Errorf("InsertHistorySQL() = %q, want table %s", stmt, tt.wantHistory)
+			}
+
+			for _, sqlFunc := range []func() (string, []any){tt.driver.MigrationTableExistsSQL, tt.driver.MigrationTableColumnsSQL} {
+				stmt, args := sqlFunc()
+				if fmt.Sprint(args) != fmt.Sprint(tt.wantArgs) {
+					t.Errorf("%q args = %v, want %v", stmt, args, tt.wantArgs)
+				}
+			}
+		})
+	}
+}
+
+func TestNewDBRepo_MigrationTable(t *testing.T) {
+	tests := []struct {
+		name         string
+		connData     DBConnectionData
+		wantLockName string
+		wantIdentity string
+		wantErr      bool
+	}{
+		{name: "default", wantLockName: "dbmigrator_schema_migration", wantIdentity: "postgres://@:/app/schema_migration"},
+		{name: "configured", connData: DBConnectionData{MigrationSchema: "ops", MigrationTable: "billing_migration"}, wantLockName: "dbmigrator_ops.billing_migration", wantIdentity: "postgres://@:/app/ops.billing_migration"},
+		{name: "long lock name", connData: DBConnectionData{MigrationSchema: strings.Repeat("s", 40), MigrationTable: strings.Repeat("t", 40)}, wantLockName: "dbmigrator_f1adc3753852fbfaf7d48549bdafd646", wantIdentity: "postgres://@:/app/" + strings.Repeat("s", 40) + "." + strings.Repeat("t", 40)},
+		{name: "table at the limit", connData: DBConnectionData{MigrationTable: strings.Repeat("t", 43)}, wantLockName: "dbmigrator_" + strings.Repeat("t", 43), wantIdentity: "postgres://@:/app/" + strings.Repeat("t", 43)},
+		{name: "table too long", connData: DBConnectionData{MigrationTable: strings.Repeat("t", 44)}, wantErr: true},
+		{name: "schema with nul", connData: DBConnectionData{MigrationSchema: "ops\x00"}, wantErr: true},
+		{name: "table with white space", connData: DBConnectionData{MigrationTable: " migrations"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.connData.DBName = "app"
+			r, err := NewDBRepo(DBDRIVER_POSTGRES, tt.connData, nil)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidIdentifier) {
+					t.Errorf("NewDBRepo() error = %v, want %v", err, ErrInvalidIdentifier)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("NewDBRepo() error = %v", err)
+			}
+
+			if got := r.lockName(); got != tt.wantLockName {
+				t.Errorf("DBRepo.lockName() = %q, want %q", got, tt.wantLockName)
+			}
+
+			if got := r.Identity(); got != tt.wantIdentity {
+				t.Errorf("DBRepo.Identity() = %q, want %q", got, tt.wantIdentity)
+			}
+
+			if index := MigrationTableName(tt.connData.MigrationTable) + HistoryTableSuffix + versionIndexSuffix; len(index) > maxIdentifierLength {
+				t.Errorf("history index %q is longer than %d bytes", index, maxIdentifierLength)
+			}
+		})
+	}
+}
+
+func TestRegisterDriver(t *testing.T) {
+	RegisterDriver("fake_registry", func(connData DBConnectionData) DBDriver {
+		return &fakeDBDriver{PostgresDBDriver: PostgresDBDriver{table: connData.MigrationTable}}
+	})
+
+	r, err := NewDBRepo("Fake_Registry", DBConnectionData{MigrationTable: "app_migration"}, nil)
+	if err != nil {
+		t.Fatalf("NewDBRepo() error = %v", err)
+	}
+
+	if stmt := r.driver.CurrentVersionSQL(); !strings.HasSuffix(stmt, `"public"."app_migration"`) {
+		t.Errorf("registered driver CurrentVersionSQL() = %q, want the configured migration table", stmt)
+	}
+
+	_, err = NewDBRepo("unknown", DBConnectionData{}, nil)
+	if !errors.Is(err, ErrInvalidDriver) || !strings.Contains(err.Error(), "FAKE_REGISTRY, MYSQL, POSTGRES") {
+		t.Errorf("NewDBRepo() error = %v, want %v listing the registered drivers", err, ErrInvalidDriver)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("RegisterDriver() must panic for a duplicate driver name")
+		}
+	}()
+	RegisterDriver(DBDRIVER_POSTGRES, func(connData DBConnectionData) DBDriver { return &PostgresDBDriver{} })
+}
+
+func TestDBRepo_Lock(t *testing.T) {
+	tests := []struct {
+		name       string
+		lockHolder string
+		wantErr    bool
+	}{
+		{name: "lock acquired"},
+		{name: "lock held", lockHolder: "pid 42 (deploy@10.0.0.1, dbmigrator)", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := DBRepo{
+				app:    &config.AppConfig{LockTimeout: time.Millisecond},
+				driver: &fakeDBDriver{transactionalDDL: true},
+				db:     sql.OpenDB(&fakeDB{lockHolder: tt.lockHolder}),
+			}
+			defer r.db.Close()
+
+			err := r.Lock()
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("DBRepo.Lock() error = %v", err)
+				}
+
+				if err := r.Unlock(); err != nil {
+					t.Errorf("DBRepo.Unlock() error = %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrLockTimeout) || !strings.Contains(err.Error(), "held by "+tt.lockHolder) {
+				t.Errorf("DBRepo.Lock() error = %v, want %v naming %q", err, ErrLockTimeout, tt.lockHolder)
+			}
+
+			if r.lockConn != nil {
+				t.Errorf("DBRepo.Lock() must not keep the lock connection after a timeout")
+			}
+		})
+	}
+}
+
+func TestDBRepo_CloseSQLiteMemory(t *testing.T) {
+	r, err := NewDBRepo(DBDRIVER_SQLITE, DBConnectionData{DBName: SQLITE_MEMORY}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := r.ConnectToDB(); err != nil {
+			t.Fatalf("DBRepo.ConnectToDB() error = %v", err)
+		}
+
+		// The in-memory db must be empty after the repo was closed and reopened
+		exists, err := r.MigrationTableExists()
+		if err != nil || exists {
+			t.Fatalf("DBRepo.MigrationTableExists() = %v, %v, want a new in-memory db", exists, err)
+		}
+
+		if err := r.SetupMigrationTable(); err != nil {
+			t.Fatalf("DBRepo.SetupMigrationTable() error = %v", err)
+		}
+
+		d := r.driver.(*SQLiteDBDriver)
+		memDB, name := d.memDB, d.memoryName
+		if err := r.Close(); err != nil {
+			t.Fatalf("DBRepo.Close() error = %v", err)
+		}
+
+		if d.memory != nil || memDB.Ping() == nil {
+			t.Errorf("DBRepo.Close() must close the connections of the in-memory db")
+		}
+
+		// The memdb VFS deletes the db once its last connection was closed
+		db, err := sql.Open("sqlite", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var tables int
+		err = db.QueryRow(`select count(*) from sqlite_master`).Scan(&tables)
+		db.Close()
+		if err != nil || tables != 0 {
+			t.Errorf("in-memory db %s has %d tables after DBRepo.Close(), %v, want it to be released", name, tables, err)
+		}
+	}
+}
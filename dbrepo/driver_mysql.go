@@ -4,48 +4,248 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/dhanekom/dbmigrator/models"
+	"github.com/go-sql-driver/mysql"
 )
 
+// reMySQLSyntaxError matches the position in the message of a MySQL syntax error, e.g.
+// "... for the right syntax to use near 'intt)' at line 2"
+var reMySQLSyntaxError = regexp.MustCompile(`(?s)near '(.*)' at line (\d+)$`)
+
+// MySQLDBDriver builds the SQL for MySQL. The migration tables are created in the database named by schema, which
+// defaults to the database of the connection
 type MySQLDBDriver struct {
+	schema string
+	table  string
+}
 
+func init() {
+	RegisterDriver(DBDRIVER_MYSQL, func(connData DBConnectionData) DBDriver {
+		return NewMySQLDBDriver(connData)
+	})
 }
 
-func (d *MySQLDBDriver) Open(dbConnData DBConnectionData) (*sql.DB, error){
-	dataSourceName := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?autocommit=true&loc=Local&multiStatements=true", dbConnData.DBUser, dbConnData.DBPassword, dbConnData.DBHost, dbConnData.DBPort, dbConnData.DBName)
+// NewMySQLDBDriver creates a *MySQLDBDriver for the migration table and schema of connData. Drivers of other dbs can embed
+// it and override the methods that differ
+func NewMySQLDBDriver(connData DBConnectionData) *MySQLDBDriver {
+	return &MySQLDBDriver{schema: connData.MigrationSchema, table: connData.MigrationTable}
+}
+
+func (d *MySQLDBDriver) Open(dbConnData DBConnectionData) (*sql.DB, error) {
+	dataSourceName := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?autocommit=true&loc=Local&parseTime=true&multiStatements=true", dbConnData.DBUser, dbConnData.DBPassword, dbConnData.DBHost, dbConnData.DBPort, dbConnData.DBName)
 	myDB, err := sql.Open("mysql", dataSourceName)
 	if err != nil {
-	  return nil, err
+		return nil, err
 	}
 
 	return myDB, nil
 }
 
+// tableName returns the quoted, schema qualified name of the migration table with suffix appended to it
+func (d *MySQLDBDriver) tableName(suffix string) string {
+	name := QuoteIdentifier(MigrationTableName(d.table)+suffix, '`')
+	if d.schema == "" {
+		return name
+	}
+
+	return QuoteIdentifier(d.schema, '`') + "." + name
+}
+
+// schemaCondition returns the information_schema condition and args that select the migration table
+func (d *MySQLDBDriver) schemaCondition() (string, []any) {
+	if d.schema == "" {
+		return `table_schema = database() and table_name = ?`, []any{MigrationTableName(d.table)}
+	}
+
+	return `table_schema = ? and table_name = ?`, []any{d.schema, MigrationTableName(d.table)}
+}
+
 func (d *MySQLDBDriver) SetupMigrationTableSQL() string {
-	return `CREATE TABLE IF NOT EXISTS schema_migration (
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
 		version varchar(15) NOT null,
 		created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		UNIQUE INDEX schema_migration_version_idx (version)
-	);`
+		UNIQUE INDEX %s (version)
+	);`, d.tableName(""), QuoteIdentifier(MigrationTableName(d.table)+versionIndexSuffix, '`'))
 }
 
 func (d *MySQLDBDriver) MigrateDBSQL(migrationDirection string) (string, error) {
-  switch strings.ToLower(migrationDirection) {
+	switch strings.ToLower(migrationDirection) {
 	case "up":
-		return `insert into schema_migration (version) values (?)`, nil
+		return `insert into ` + d.tableName("") + ` (version, checksum, description, duration_ms, db_user, os_user, hostname, tool_version) values (?, ?, ?, ?, current_user(), ?, ?, ?)`, nil
 	case "down":
-		return `delete from schema_migration where version = ?`, nil
+		return `delete from ` + d.tableName("") + ` where version = ?`, nil
 	default:
-		return "", errors.New("migrationDirection  - migration direction must be up or down")
+		return "", fmt.Errorf("migrationDirection - %w", ErrInvalidDirection)
 	}
 }
 
 func (d *MySQLDBDriver) CurrentVersionSQL() string {
-	return `select coalesce(max(version), '') as version from schema_migration`
+	return `select coalesce(max(version), '') as version from ` + d.tableName("")
 }
 
 func (d *MySQLDBDriver) MigratedVersionsSQL() string {
-	return `select version from schema_migration order by version`
-}
\ No newline at end of file
+	return `select version from ` + d.tableName("") + ` order by version`
+}
+
+func (d *MySQLDBDriver) AppliedMigrationsSQL() string {
+	return `select version, created_on, checksum, dirty, description, duration_ms, db_user, os_user, hostname, tool_version from ` + d.tableName("") + ` order by version`
+}
+
+func (d *MySQLDBDriver) MigrationTableExistsSQL() (string, []any) {
+	condition, args := d.schemaCondition()
+	return `select count(*) > 0 from information_schema.tables where ` + condition, args
+}
+
+func (d *MySQLDBDriver) MigrationTableColumnsSQL() (string, []any) {
+	condition, args := d.schemaCondition()
+	return `select column_name from information_schema.columns where ` + condition, args
+}
+
+func (d *MySQLDBDriver) AddMigrationTableColumnSQL(column string) (string, error) {
+	definition, err := MigrationTableColumnDefinition(column)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", d.tableName(""), column, definition), nil
+}
+
+func (d *MySQLDBDriver) TryLockSQL(lockName string) (string, []any) {
+	return `select coalesce(get_lock(?, 0), 0) = 1`, []any{lockName}
+}
+
+func (d *MySQLDBDriver) UnlockSQL(lockName string) (string, []any) {
+	return `select release_lock(?)`, []any{lockName}
+}
+
+func (d *MySQLDBDriver) LockHolderSQL(lockName string) (string, []any) {
+	return `select coalesce((select concat('connection ', p.id, ' (', p.user, '@', p.host, ')')
+		from information_schema.processlist p
+		where p.id = is_used_lock(?)), '')`, []any{lockName}
+}
+
+// SupportsTransactionalDDL returns false because MySQL implicitly commits DDL statements
+func (d *MySQLDBDriver) SupportsTransactionalDDL() bool {
+	return false
+}
+
+func (d *MySQLDBDriver) MarkDirtySQL(migrationDirection string) (string, error) {
+	switch strings.ToLower(migrationDirection) {
+	case "up":
+		return `insert into ` + d.tableName("") + ` (version, checksum, description, duration_ms, db_user, os_user, hostname, tool_version, dirty) values (?, ?, ?, ?, current_user(), ?, ?, ?, true)`, nil
+	case "down":
+		return `update ` + d.tableName("") + ` set dirty = true where version = ?`, nil
+	default:
+		return "", fmt.Errorf("migrationDirection - %w", ErrInvalidDirection)
+	}
+}
+
+func (d *MySQLDBDriver) ClearDirtySQL(migrationDirection string) (string, error) {
+	switch strings.ToLower(migrationDirection) {
+	case "up":
+		return `update ` + d.tableName("") + ` set dirty = false, duration_ms = greatest(duration_ms, ?) where version = ? and dirty`, nil
+	case "down":
+		return `delete from ` + d.tableName("") + ` where version = ? and dirty`, nil
+	default:
+		return "", fmt.Errorf("migrationDirection - %w", ErrInvalidDirection)
+	}
+}
+
+func (d *MySQLDBDriver) SplitStatements(script string) ([]Statement, error) {
+	return SplitStatements(script, MySQLDialect)
+}
+
+// ErrorOffset derives a byte offset in stmt from the text and line that MySQL reports for syntax errors
+func (d *MySQLDBDriver) ErrorOffset(stmt string, err error) int {
+	var myErr *mysql.MySQLError
+	if !errors.As(err, &myErr) {
+		return -1
+	}
+
+	matches := reMySQLSyntaxError.FindStringSubmatch(myErr.Message)
+	if matches == nil {
+		return -1
+	}
+
+	line, err := strconv.Atoi(matches[2])
+	if err != nil || line < 1 {
+		return -1
+	}
+
+	lineStart := 0
+	for n := 1; n < line; n++ {
+		i := strings.IndexByte(stmt[lineStart:], '\n')
+		if i < 0 {
+			return -1
+		}
+		lineStart += i + 1
+	}
+
+	near := matches[1]
+	if near == "" {
+		return len(stmt)
+	}
+
+	// MySQL truncates the text after the error, so only the part before the first line break is searched for
+	if i := strings.IndexByte(near, '\n'); i >= 0 {
+		near = near[:i]
+	}
+
+	i := strings.Index(stmt[lineStart:], near)
+	if i < 0 {
+		return lineStart
+	}
+
+	return lineStart + i
+}
+
+func (d *MySQLDBDriver) SetupHistoryTableSQL() string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id bigint NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		version varchar(15) NOT NULL,
+		direction varchar(4) NOT NULL,
+		command varchar(16) NOT NULL,
+		outcome varchar(16) NOT NULL,
+		error_message text NOT NULL,
+		duration_ms bigint NOT NULL DEFAULT 0,
+		db_user varchar(128) NOT NULL DEFAULT '',
+		os_user varchar(128) NOT NULL DEFAULT '',
+		hostname varchar(255) NOT NULL DEFAULT '',
+		tool_version varchar(64) NOT NULL DEFAULT '',
+		created_on datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+		INDEX %s (version)
+	);`, d.tableName(HistoryTableSuffix), QuoteIdentifier(MigrationTableName(d.table)+HistoryTableSuffix+versionIndexSuffix, '`'))
+}
+
+func (d *MySQLDBDriver) InsertHistorySQL() string {
+	return `insert into ` + d.tableName(HistoryTableSuffix) + ` (version, direction, command, outcome, error_message, duration_ms, db_user, os_user, hostname, tool_version)
+		values (?, ?, ?, ?, ?, ?, current_user(), ?, ?, ?)`
+}
+
+func (d *MySQLDBDriver) HistorySQL(filter models.HistoryFilter) (string, []any) {
+	var conditions []string
+	var args []any
+	if filter.Version != "" {
+		conditions = append(conditions, "version = ?")
+		args = append(args, filter.Version)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "created_on >= ?")
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "created_on <= ?")
+		args = append(args, filter.To)
+	}
+
+	stmt := `select id, version, direction, command, outcome, error_message, duration_ms, db_user, os_user, hostname, tool_version, created_on from ` + d.tableName(HistoryTableSuffix)
+	if len(conditions) > 0 {
+		stmt += " where " + strings.Join(conditions, " and ")
+	}
+
+	return stmt + " order by id", args
+}
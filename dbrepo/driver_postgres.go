@@ -4,51 +4,228 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"strings"
 
-	_ "github.com/jackc/pgconn"
+	"github.com/dhanekom/dbmigrator/models"
+	"github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
 )
 
+// PostgresDBDriver builds the SQL for PostgreSQL. The migration tables are created in schema, which defaults to
+// DefaultPostgresSchema
 type PostgresDBDriver struct {
+	schema string
+	table  string
+}
+
+func init() {
+	RegisterDriver(DBDRIVER_POSTGRES, func(connData DBConnectionData) DBDriver {
+		return NewPostgresDBDriver(connData)
+	})
+}
 
+// NewPostgresDBDriver creates a *PostgresDBDriver for the migration table and schema of connData. Drivers of other dbs can embed
+// it and override the methods that differ
+func NewPostgresDBDriver(connData DBConnectionData) *PostgresDBDriver {
+	return &PostgresDBDriver{schema: connData.MigrationSchema, table: connData.MigrationTable}
 }
 
-func (d *PostgresDBDriver) Open(dbConnData DBConnectionData) (*sql.DB, error){
+func (d *PostgresDBDriver) Open(dbConnData DBConnectionData) (*sql.DB, error) {
 	dataSourceName := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s", dbConnData.DBHost, dbConnData.DBPort, dbConnData.DBName, dbConnData.DBUser, dbConnData.DBPassword, dbConnData.DBSSL)
 	myDB, err := sql.Open("pgx", dataSourceName)
 	if err != nil {
-	  return nil, err
+		return nil, err
 	}
 
 	return myDB, nil
 }
 
+// schemaName returns the schema of the migration tables
+func (d *PostgresDBDriver) schemaName() string {
+	if d.schema == "" {
+		return DefaultPostgresSchema
+	}
+
+	return d.schema
+}
+
+// tableName returns the quoted, schema qualified name of the migration table with suffix appended to it
+func (d *PostgresDBDriver) tableName(suffix string) string {
+	return QuoteIdentifier(d.schemaName(), '"') + "." + QuoteIdentifier(MigrationTableName(d.table)+suffix, '"')
+}
+
 func (d *PostgresDBDriver) SetupMigrationTableSQL() string {
-	return `CREATE TABLE IF NOT EXISTS public.schema_migration (
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
 		"version" varchar(15) NOT NULL,
 		"created_on" timestamp(6) NOT NULL DEFAULT now()
 	);
-	CREATE UNIQUE INDEX IF NOT EXISTS schema_migration_version_idx ON public.schema_migration USING btree (version);
-	ALTER TABLE public.schema_migration ADD COLUMN IF NOT EXISTS "created_on" timestamp(6) NOT NULL DEFAULT now();`
+	CREATE UNIQUE INDEX IF NOT EXISTS %[2]s ON %[1]s USING btree (version);
+	ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS "created_on" timestamp(6) NOT NULL DEFAULT now();`,
+		d.tableName(""), QuoteIdentifier(MigrationTableName(d.table)+versionIndexSuffix, '"'))
 }
 
 func (d *PostgresDBDriver) MigrateDBSQL(migrationDirection string) (string, error) {
-  switch strings.ToLower(migrationDirection) {
+	switch strings.ToLower(migrationDirection) {
 	case "up":
-		return `insert into public.schema_migration (version) values ($1)`, nil
+		return `insert into ` + d.tableName("") + ` (version, checksum, description, duration_ms, db_user, os_user, hostname, tool_version) values ($1, $2, $3, $4, current_user, $5, $6, $7)`, nil
 	case "down":
-		return `delete from public.schema_migration where version = $1`, nil
+		return `delete from ` + d.tableName("") + ` where version = $1`, nil
 	default:
-		return "", errors.New("migrationDirection  - migration direction must be up or down")
+		return "", fmt.Errorf("migrationDirection - %w", ErrInvalidDirection)
 	}
 }
 
 func (d *PostgresDBDriver) CurrentVersionSQL() string {
-	return `select coalesce(max(version), '') as version from public.schema_migration`
+	return `select coalesce(max(version), '') as version from ` + d.tableName("")
 }
 
 func (d *PostgresDBDriver) MigratedVersionsSQL() string {
-	return `select version from public.schema_migration order by version`
-}
\ No newline at end of file
+	return `select version from ` + d.tableName("") + ` order by version`
+}
+
+func (d *PostgresDBDriver) AppliedMigrationsSQL() string {
+	return `select version, created_on, checksum, dirty, description, duration_ms, db_user, os_user, hostname, tool_version from ` + d.tableName("") + ` order by version`
+}
+
+func (d *PostgresDBDriver) MigrationTableExistsSQL() (string, []any) {
+	return `select exists (select 1 from information_schema.tables where table_schema = $1 and table_name = $2)`, []any{d.schemaName(), MigrationTableName(d.table)}
+}
+
+func (d *PostgresDBDriver) MigrationTableColumnsSQL() (string, []any) {
+	return `select column_name from information_schema.columns where table_schema = $1 and table_name = $2`, []any{d.schemaName(), MigrationTableName(d.table)}
+}
+
+func (d *PostgresDBDriver) AddMigrationTableColumnSQL(column string) (string, error) {
+	definition, err := MigrationTableColumnDefinition(column)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", d.tableName(""), column, definition), nil
+}
+
+func (d *PostgresDBDriver) TryLockSQL(lockName string) (string, []any) {
+	return `select pg_try_advisory_lock($1)`, []any{advisoryLockKey(lockName)}
+}
+
+func (d *PostgresDBDriver) UnlockSQL(lockName string) (string, []any) {
+	return `select pg_advisory_unlock($1)`, []any{advisoryLockKey(lockName)}
+}
+
+func (d *PostgresDBDriver) LockHolderSQL(lockName string) (string, []any) {
+	return `select coalesce(string_agg(format('pid %s (%s@%s, %s)', a.pid, a.usename, coalesce(host(a.client_addr), 'local'), a.application_name), ', '), '')
+		from pg_locks l
+		join pg_stat_activity a on a.pid = l.pid
+		where l.locktype = 'advisory' and l.granted and l.objsubid = 1
+			and l.classid::bigint = (($1::bigint >> 32) & 4294967295)
+			and l.objid::bigint = ($1::bigint & 4294967295)`, []any{advisoryLockKey(lockName)}
+}
+
+// advisoryLockKey converts a lock name to the 64-bit key required by the advisory lock functions
+func advisoryLockKey(lockName string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(lockName))
+	return int64(h.Sum64())
+}
+
+func (d *PostgresDBDriver) SupportsTransactionalDDL() bool {
+	return true
+}
+
+func (d *PostgresDBDriver) MarkDirtySQL(migrationDirection string) (string, error) {
+	switch strings.ToLower(migrationDirection) {
+	case "up":
+		return `insert into ` + d.tableName("") + ` (version, checksum, description, duration_ms, db_user, os_user, hostname, tool_version, dirty) values ($1, $2, $3, $4, current_user, $5, $6, $7, true)`, nil
+	case "down":
+		return `update ` + d.tableName("") + ` set dirty = true where version = $1`, nil
+	default:
+		return "", fmt.Errorf("migrationDirection - %w", ErrInvalidDirection)
+	}
+}
+
+func (d *PostgresDBDriver) ClearDirtySQL(migrationDirection string) (string, error) {
+	switch strings.ToLower(migrationDirection) {
+	case "up":
+		return `update ` + d.tableName("") + ` set dirty = false, duration_ms = greatest(duration_ms, $1) where version = $2 and dirty`, nil
+	case "down":
+		return `delete from ` + d.tableName("") + ` where version = $1 and dirty`, nil
+	default:
+		return "", fmt.Errorf("migrationDirection - %w", ErrInvalidDirection)
+	}
+}
+
+func (d *PostgresDBDriver) SplitStatements(script string) ([]Statement, error) {
+	return SplitStatements(script, PostgresDialect)
+}
+
+// ErrorOffset converts the character position that Postgres reports for an error to a byte offset in stmt
+func (d *PostgresDBDriver) ErrorOffset(stmt string, err error) int {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Position <= 0 {
+		return -1
+	}
+
+	chars := 0
+	for i := range stmt {
+		chars++
+		if chars == int(pgErr.Position) {
+			return i
+		}
+	}
+
+	if chars+1 == int(pgErr.Position) {
+		return len(stmt)
+	}
+
+	return -1
+}
+
+func (d *PostgresDBDriver) SetupHistoryTableSQL() string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+		"id" bigserial PRIMARY KEY,
+		"version" varchar(15) NOT NULL,
+		"direction" varchar(4) NOT NULL,
+		"command" varchar(16) NOT NULL,
+		"outcome" varchar(16) NOT NULL,
+		"error_message" text NOT NULL,
+		"duration_ms" bigint NOT NULL DEFAULT 0,
+		"db_user" varchar(128) NOT NULL DEFAULT '',
+		"os_user" varchar(128) NOT NULL DEFAULT '',
+		"hostname" varchar(255) NOT NULL DEFAULT '',
+		"tool_version" varchar(64) NOT NULL DEFAULT '',
+		"created_on" timestamp(6) NOT NULL DEFAULT now()
+	);
+	CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s USING btree (version);`,
+		d.tableName(HistoryTableSuffix), QuoteIdentifier(MigrationTableName(d.table)+HistoryTableSuffix+versionIndexSuffix, '"'))
+}
+
+func (d *PostgresDBDriver) InsertHistorySQL() string {
+	return `insert into ` + d.tableName(HistoryTableSuffix) + ` (version, direction, command, outcome, error_message, duration_ms, db_user, os_user, hostname, tool_version)
+		values ($1, $2, $3, $4, $5, $6, current_user, $7, $8, $9)`
+}
+
+func (d *PostgresDBDriver) HistorySQL(filter models.HistoryFilter) (string, []any) {
+	var conditions []string
+	var args []any
+	if filter.Version != "" {
+		args = append(args, filter.Version)
+		conditions = append(conditions, fmt.Sprintf("version = $%d", len(args)))
+	}
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		conditions = append(conditions, fmt.Sprintf("created_on >= $%d", len(args)))
+	}
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		conditions = append(conditions, fmt.Sprintf("created_on <= $%d", len(args)))
+	}
+
+	stmt := `select id, version, direction, command, outcome, error_message, duration_ms, db_user, os_user, hostname, tool_version, created_on from ` + d.tableName(HistoryTableSuffix)
+	if len(conditions) > 0 {
+		stmt += " where " + strings.Join(conditions, " and ")
+	}
+
+	return stmt + " order by id", args
+}
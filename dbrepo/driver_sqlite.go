@@ -0,0 +1,325 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dhanekom/dbmigrator/models"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// SQLITE_MEMORY is the DBName of a private in-memory SQLite db
+	SQLITE_MEMORY = ":memory:"
+	// sqliteTimeFormat is the format of the created_on column of the history table
+	sqliteTimeFormat = "2006-01-02 15:04:05.000"
+)
+
+// sqliteMemoryDBs numbers the in-memory dbs so that every driver gets its own db
+var sqliteMemoryDBs atomic.Int64
+
+// SQLiteDBDriver builds the SQL for SQLite. DBConnectionData.DBName is the path of the db file or SQLITE_MEMORY for an
+// in-memory db, the other connection settings are not used. The migration tables are created in the main db unless
+// schema names another attached db.
+//
+// SQLite has no migration lock. Lock always succeeds, so migration commands of other processes may run against the same
+// db file at the same time. A migration is still never applied twice, because its version is recorded in the same
+// transaction as the migration. SQLite has no db users either, so the db user of applied migrations is always empty
+type SQLiteDBDriver struct {
+	schema string
+	table  string
+
+	mu sync.Mutex
+	// memory keeps the in-memory db alive while the pool of the DBRepo closes and reopens its connections. It is a
+	// connection of memDB and both are closed by Close
+	memDB      *sql.DB
+	memory     *sql.Conn
+	memoryName string
+	memoryID   int64
+}
+
+// memoryDBNamer is implemented by drivers that open a separate in-memory db for every driver, so that DBRepo.Identity
+// can tell the dbs apart
+type memoryDBNamer interface {
+	memoryDBName() string
+}
+
+func init() {
+	RegisterDriver(DBDRIVER_SQLITE, func(connData DBConnectionData) DBDriver {
+		return NewSQLiteDBDriver(connData)
+	})
+}
+
+// NewSQLiteDBDriver creates a *SQLiteDBDriver for the migration table and schema of connData. Drivers of other dbs can embed
+// it and override the methods that differ
+func NewSQLiteDBDriver(connData DBConnectionData) *SQLiteDBDriver {
+	return &SQLiteDBDriver{schema: connData.MigrationSchema, table: connData.MigrationTable}
+}
+
+func (d *SQLiteDBDriver) Open(dbConnData DBConnectionData) (*sql.DB, error) {
+	if dbConnData.DBName == "" {
+		return nil, fmt.Errorf("DBName must be the path of the db file or %s", SQLITE_MEMORY)
+	}
+
+	dataSourceName := dbConnData.DBName
+	if dataSourceName == SQLITE_MEMORY {
+		if err := d.openMemory(); err != nil {
+			return nil, err
+		}
+
+		dataSourceName = d.memoryName
+	}
+
+	separator := "?"
+	if strings.Contains(dataSourceName, "?") {
+		separator = "&"
+	}
+
+	myDB, err := sql.Open("sqlite", dataSourceName+separator+"_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, err
+	}
+
+	return myDB, nil
+}
+
+// openMemory opens the in-memory db of the driver the first time it is needed. The db is shared by all connections of
+// the driver and lives until the driver is closed
+func (d *SQLiteDBDriver) openMemory() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.memory != nil {
+		return nil
+	}
+
+	id := sqliteMemoryDBs.Add(1)
+	name := fmt.Sprintf("file:/dbmigrator_memory_%d?vfs=memdb", id)
+	memDB, err := sql.Open("sqlite", name)
+	if err != nil {
+		return err
+	}
+
+	conn, err := memDB.Conn(context.Background())
+	if err != nil {
+		memDB.Close()
+		return err
+	}
+
+	d.memDB, d.memory, d.memoryName, d.memoryID = memDB, conn, name, id
+	return nil
+}
+
+// memoryDBName returns SQLITE_MEMORY followed by the number of the in-memory db of the driver. The number is missing if
+// the in-memory db is not open
+func (d *SQLiteDBDriver) memoryDBName() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.memory == nil {
+		return SQLITE_MEMORY
+	}
+
+	return fmt.Sprintf("%s%d", SQLITE_MEMORY, d.memoryID)
+}
+
+// Close releases the in-memory db. Its data is lost and a new, empty in-memory db is created when the driver is opened
+// again. Close does nothing for a db file
+func (d *SQLiteDBDriver) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.memory == nil {
+		return nil
+	}
+
+	err := errors.Join(d.memory.Close(), d.memDB.Close())
+	d.memDB, d.memory, d.memoryName, d.memoryID = nil, nil, "", 0
+	return err
+}
+
+// tableName returns the quoted, schema qualified name of the migration table with suffix appended to it
+func (d *SQLiteDBDriver) tableName(suffix string) string {
+	return d.qualify(MigrationTableName(d.table) + suffix)
+}
+
+// qualify quotes name and qualifies it with the schema if one is set
+func (d *SQLiteDBDriver) qualify(name string) string {
+	if d.schema == "" {
+		return QuoteIdentifier(name, '"')
+	}
+
+	return QuoteIdentifier(d.schema, '"') + "." + QuoteIdentifier(name, '"')
+}
+
+func (d *SQLiteDBDriver) SetupMigrationTableSQL() string {
+	table := MigrationTableName(d.table)
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		"version" varchar(15) NOT NULL,
+		"created_on" datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s ("version");`, d.tableName(""), d.qualify(table+versionIndexSuffix), QuoteIdentifier(table, '"'))
+}
+
+func (d *SQLiteDBDriver) MigrateDBSQL(migrationDirection string) (string, error) {
+	switch strings.ToLower(migrationDirection) {
+	case "up":
+		return `insert into ` + d.tableName("") + ` (version, checksum, description, duration_ms, os_user, hostname, tool_version) values (?, ?, ?, ?, ?, ?, ?)`, nil
+	case "down":
+		return `delete from ` + d.tableName("") + ` where version = ?`, nil
+	default:
+		return "", fmt.Errorf("migrationDirection - %w", ErrInvalidDirection)
+	}
+}
+
+func (d *SQLiteDBDriver) CurrentVersionSQL() string {
+	return `select coalesce(max(version), '') as version from ` + d.tableName("")
+}
+
+func (d *SQLiteDBDriver) MigratedVersionsSQL() string {
+	return `select version from ` + d.tableName("") + ` order by version`
+}
+
+// AppliedMigrationsSQL returns an empty db user, because SQLite has no db users
+func (d *SQLiteDBDriver) AppliedMigrationsSQL() string {
+	return `select version, created_on, checksum, dirty, description, duration_ms, '' as db_user, os_user, hostname, tool_version from ` + d.tableName("") + ` order by version`
+}
+
+func (d *SQLiteDBDriver) MigrationTableExistsSQL() (string, []any) {
+	master := "sqlite_master"
+	if d.schema != "" {
+		master = QuoteIdentifier(d.schema, '"') + "." + master
+	}
+
+	return `select count(*) > 0 from ` + master + ` where type = 'table' and name = ?`, []any{MigrationTableName(d.table)}
+}
+
+func (d *SQLiteDBDriver) MigrationTableColumnsSQL() (string, []any) {
+	if d.schema == "" {
+		return `select name from pragma_table_info(?)`, []any{MigrationTableName(d.table)}
+	}
+
+	return `select name from pragma_table_info(?, ?)`, []any{MigrationTableName(d.table), d.schema}
+}
+
+// AddMigrationTableColumnSQL skips the db_user column, because SQLite has no db users
+func (d *SQLiteDBDriver) AddMigrationTableColumnSQL(column string) (string, error) {
+	definition, err := MigrationTableColumnDefinition(column)
+	if err != nil {
+		return "", err
+	}
+
+	if column == "db_user" {
+		return "", nil
+	}
+
+	return fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", d.tableName(""), column, definition), nil
+}
+
+// TryLockSQL always acquires the lock, because SQLite has no migration lock
+func (d *SQLiteDBDriver) TryLockSQL(lockName string) (string, []any) {
+	return `select 1`, nil
+}
+
+func (d *SQLiteDBDriver) UnlockSQL(lockName string) (string, []any) {
+	return `select 1`, nil
+}
+
+func (d *SQLiteDBDriver) LockHolderSQL(lockName string) (string, []any) {
+	return `select ''`, nil
+}
+
+func (d *SQLiteDBDriver) SupportsTransactionalDDL() bool {
+	return true
+}
+
+func (d *SQLiteDBDriver) MarkDirtySQL(migrationDirection string) (string, error) {
+	switch strings.ToLower(migrationDirection) {
+	case "up":
+		return `insert into ` + d.tableName("") + ` (version, checksum, description, duration_ms, os_user, hostname, tool_version, dirty) values (?, ?, ?, ?, ?, ?, ?, true)`, nil
+	case "down":
+		return `update ` + d.tableName("") + ` set dirty = true where version = ?`, nil
+	default:
+		return "", fmt.Errorf("migrationDirection - %w", ErrInvalidDirection)
+	}
+}
+
+func (d *SQLiteDBDriver) ClearDirtySQL(migrationDirection string) (string, error) {
+	switch strings.ToLower(migrationDirection) {
+	case "up":
+		return `update ` + d.tableName("") + ` set dirty = false, duration_ms = max(duration_ms, ?) where version = ? and dirty`, nil
+	case "down":
+		return `delete from ` + d.tableName("") + ` where version = ? and dirty`, nil
+	default:
+		return "", fmt.Errorf("migrationDirection - %w", ErrInvalidDirection)
+	}
+}
+
+func (d *SQLiteDBDriver) SplitStatements(script string) ([]Statement, error) {
+	return SplitStatements(script, SQLiteDialect)
+}
+
+// ErrorOffset returns -1 because SQLite does not report the position of an error
+func (d *SQLiteDBDriver) ErrorOffset(stmt string, err error) int {
+	return -1
+}
+
+func (d *SQLiteDBDriver) SetupHistoryTableSQL() string {
+	table := MigrationTableName(d.table) + HistoryTableSuffix
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		"id" integer PRIMARY KEY AUTOINCREMENT,
+		"version" varchar(15) NOT NULL,
+		"direction" varchar(4) NOT NULL,
+		"command" varchar(16) NOT NULL,
+		"outcome" varchar(16) NOT NULL,
+		"error_message" text NOT NULL,
+		"duration_ms" bigint NOT NULL DEFAULT 0,
+		"os_user" varchar(128) NOT NULL DEFAULT '',
+		"hostname" varchar(255) NOT NULL DEFAULT '',
+		"tool_version" varchar(64) NOT NULL DEFAULT '',
+		"created_on" datetime NOT NULL DEFAULT (strftime('%%Y-%%m-%%d %%H:%%M:%%f', 'now'))
+	);
+	CREATE INDEX IF NOT EXISTS %s ON %s ("version");`, d.tableName(HistoryTableSuffix), d.qualify(table+versionIndexSuffix), QuoteIdentifier(table, '"'))
+}
+
+func (d *SQLiteDBDriver) InsertHistorySQL() string {
+	return `insert into ` + d.tableName(HistoryTableSuffix) + ` (version, direction, command, outcome, error_message, duration_ms, os_user, hostname, tool_version)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+}
+
+// HistorySQL passes the date range as text in the format of created_on, because SQLite compares dates as text. The db
+// user is empty, because SQLite has no db users
+func (d *SQLiteDBDriver) HistorySQL(filter models.HistoryFilter) (string, []any) {
+	var conditions []string
+	var args []any
+	if filter.Version != "" {
+		conditions = append(conditions, "version = ?")
+		args = append(args, filter.Version)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "created_on >= ?")
+		args = append(args, sqliteTime(filter.From))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "created_on <= ?")
+		args = append(args, sqliteTime(filter.To))
+	}
+
+	stmt := `select id, version, direction, command, outcome, error_message, duration_ms, '' as db_user, os_user, hostname, tool_version, created_on from ` + d.tableName(HistoryTableSuffix)
+	if len(conditions) > 0 {
+		stmt += " where " + strings.Join(conditions, " and ")
+	}
+
+	return stmt + " order by id", args
+}
+
+// sqliteTime formats t in UTC like the created_on column of the history table
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeFormat)
+}
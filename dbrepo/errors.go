@@ -0,0 +1,152 @@
+package dbrepo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dhanekom/dbmigrator/models"
+)
+
+var (
+	// ErrInvalidDriver is returned by NewDBRepo for an unknown db driver name
+	ErrInvalidDriver = errors.New("invalid db driver")
+	// ErrInvalidIdentifier is returned by NewDBRepo for a migration table or schema name that cannot be used
+	ErrInvalidIdentifier = errors.New("invalid identifier")
+	// ErrInvalidDirection is returned for a migration direction other than up or down
+	ErrInvalidDirection = errors.New("migration direction must be up or down")
+	// ErrLockAcquired is returned when Lock is called while the migration lock is already held
+	ErrLockAcquired = errors.New("migration lock already acquired")
+	// ErrLockTimeout is returned when the migration lock is held by another process for longer than the lock timeout
+	ErrLockTimeout = errors.New("could not acquire migration lock")
+	// ErrNotDirty is returned by Recover for a migration version that is not marked dirty
+	ErrNotDirty = errors.New("migration version is not dirty")
+)
+
+// DirtyError is returned when a migration failed after some of its statements may have been committed, e.g. because
+// the driver does not support transactional DDL or the migration was run without a transaction. The db may be
+// partially migrated to Version. The db must be repaired manually before the dirty mark of Version is cleared with
+// Recover
+type DirtyError struct {
+	Version string
+	Err     error
+}
+
+func (e *DirtyError) Error() string {
+	return fmt.Sprintf("%s - the db may be partially migrated to version %s. Repair the db manually and run recover", e.Err, e.Version)
+}
+
+func (e *DirtyError) Unwrap() error {
+	return e.Err
+}
+
+// MigrationError is returned when a statement of a migration file fails. Line and Column give the position of the
+// error in the file. Column is 0 if the driver did not report a position within the statement. Excerpt holds the lines
+// of the file around the error. Rendered is set if the file is a template. Line, Column and Excerpt then refer to the
+// rendered SQL, because positions in the rendered SQL cannot be mapped back to the template
+type MigrationError struct {
+	Version   string
+	Filename  string
+	Statement int
+	Line      int
+	Column    int
+	Rendered  bool
+	SQL       string
+	Excerpt   string
+	Err       error
+}
+
+func (e *MigrationError) Error() string {
+	position := fmt.Sprintf("line %d", e.Line)
+	if e.Column > 0 {
+		position += fmt.Sprintf(", column %d", e.Column)
+	}
+	if e.Rendered {
+		position += " of the rendered SQL"
+	}
+
+	msg := fmt.Sprintf("%s statement %d at %s - %s", e.Filename, e.Statement, position, e.Err)
+	if e.Excerpt != "" {
+		msg += "\n" + e.Excerpt
+	}
+
+	return msg
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// excerptLines is the number of lines shown before and after the error line in a MigrationError excerpt
+const excerptLines = 2
+
+// newMigrationError creates a *MigrationError for stmt, the statement with index i (0-based) of script. offset is the
+// byte offset of the error in stmt.SQL or -1 if it is unknown
+func newMigrationError(version string, script models.MigrationScript, i int, stmt Statement, offset int, err error) *MigrationError {
+	result := MigrationError{
+		Version:   version,
+		Filename:  script.Filename,
+		Statement: i + 1,
+		Line:      stmt.Line,
+		Rendered:  script.Template,
+		SQL:       stmt.SQL,
+		Err:       err,
+	}
+
+	if offset >= 0 && offset <= len(stmt.SQL) && stmt.Offset+offset <= len(script.SQL) {
+		result.Line, result.Column = position(script.SQL, stmt.Offset+offset)
+	}
+
+	result.Excerpt = excerpt(script.SQL, result.Line, result.Column)
+	return &result
+}
+
+// position returns the 1-based line and column (in characters) of the byte offset in s
+func position(s string, offset int) (line, column int) {
+	before := s[:offset]
+	line = strings.Count(before, "\n") + 1
+	column = utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:]) + 1
+	return line, column
+}
+
+// excerpt returns the lines of script around line, prefixed with their line numbers. The error line is marked with >
+// and, if column is known, followed by a line with a ^ under the column
+func excerpt(script string, line, column int) string {
+	lines := strings.Split(script, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+
+	first, last := max(line-excerptLines, 1), min(line+excerptLines, len(lines))
+	width := len(strconv.Itoa(last))
+
+	var sb strings.Builder
+	for n := first; n <= last; n++ {
+		text := strings.TrimRight(lines[n-1], "\r")
+		marker := " "
+		if n == line {
+			marker = ">"
+		}
+
+		fmt.Fprintf(&sb, "%s %*d | %s\n", marker, width, n, text)
+		if n == line && column > 0 {
+			// Keep tabs so that the caret lines up with the column
+			pad := []rune(text)
+			if column-1 < len(pad) {
+				pad = pad[:column-1]
+			}
+
+			for j, r := range pad {
+				if r != '\t' {
+					pad[j] = ' '
+				}
+			}
+
+			fmt.Fprintf(&sb, "  %*s | %s^\n", width, "", string(pad))
+		}
+	}
+
+	return strings.TrimSuffix(sb.String(), "\n")
+}
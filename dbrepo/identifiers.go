@@ -0,0 +1,43 @@
+package dbrepo
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// maxIdentifierLength is the longest identifier that all supported dbs accept. Postgres truncates longer names
+	maxIdentifierLength = 63
+	maxLockNameLength   = 64
+)
+
+// MigrationTableName returns table or DefaultMigrationTable if table is not set
+func MigrationTableName(table string) string {
+	if table == "" {
+		return DefaultMigrationTable
+	}
+
+	return table
+}
+
+// QuoteIdentifier encloses name in quote so that it is used as an identifier regardless of its characters or case. A
+// quote in name is escaped by doubling it
+func QuoteIdentifier(name string, quote byte) string {
+	q := string(quote)
+	return q + strings.ReplaceAll(name, q, q+q) + q
+}
+
+// validateIdentifier checks that name can be quoted as an identifier. reserved is the number of characters that are
+// appended to name for derived identifiers. An empty name is valid because the default is used in its place
+func validateIdentifier(name string, reserved int) error {
+	switch {
+	case strings.ContainsRune(name, 0):
+		return fmt.Errorf("%w %q. Value may not contain NUL characters", ErrInvalidIdentifier, name)
+	case strings.TrimSpace(name) != name:
+		return fmt.Errorf("%w %q. Value may not start or end with white space", ErrInvalidIdentifier, name)
+	case len(name) > maxIdentifierLength-reserved:
+		return fmt.Errorf("%w %q. Value may not be longer than %d bytes", ErrInvalidIdentifier, name, maxIdentifierLength-reserved)
+	}
+
+	return nil
+}
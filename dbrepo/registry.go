@@ -0,0 +1,59 @@
+package dbrepo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DriverFactory creates the DBDriver of a DBRepo. connData holds the connection settings and the migration table and
+// schema that the SQL of the driver must use
+type DriverFactory func(connData DBConnectionData) DBDriver
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]DriverFactory)
+)
+
+// RegisterDriver makes a db driver available to NewDBRepo under name. Names are not case sensitive. Packages that
+// provide a driver usually call RegisterDriver from their init function. RegisterDriver panics if factory is nil or if
+// a driver with the same name is already registered
+func RegisterDriver(name string, factory DriverFactory) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	name = strings.ToUpper(name)
+	if factory == nil {
+		panic("dbrepo: RegisterDriver factory is nil")
+	}
+
+	if _, ok := drivers[name]; ok {
+		panic(fmt.Sprintf("dbrepo: RegisterDriver called twice for driver %s", name))
+	}
+
+	drivers[name] = factory
+}
+
+// Drivers returns the sorted names of the registered db drivers
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// driverFactory returns the factory of the driver registered under name
+func driverFactory(name string) (DriverFactory, bool) {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	factory, ok := drivers[strings.ToUpper(name)]
+	return factory, ok
+}
@@ -0,0 +1,70 @@
+package dbrepo_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dhanekom/dbmigrator/dbrepo"
+	"github.com/dhanekom/dbmigrator/models"
+)
+
+// auditDriver is a driver of another package that embeds a built-in driver and overrides some of its methods with the
+// exported helpers of dbrepo
+type auditDriver struct {
+	*dbrepo.SQLiteDBDriver
+	table      string
+	addColumns int
+	splits     int
+}
+
+func (d *auditDriver) AddMigrationTableColumnSQL(column string) (string, error) {
+	definition, err := dbrepo.MigrationTableColumnDefinition(column)
+	if err != nil {
+		return "", err
+	}
+
+	d.addColumns++
+	return fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", dbrepo.QuoteIdentifier(dbrepo.MigrationTableName(d.table), '"'), column, definition), nil
+}
+
+func (d *auditDriver) SplitStatements(script string) ([]dbrepo.Statement, error) {
+	d.splits++
+	return dbrepo.SplitStatements(script, dbrepo.SQLiteDialect)
+}
+
+func TestRegisterDriver_External(t *testing.T) {
+	var driver *auditDriver
+	dbrepo.RegisterDriver("sqlite_audit", func(connData dbrepo.DBConnectionData) dbrepo.DBDriver {
+		driver = &auditDriver{SQLiteDBDriver: dbrepo.NewSQLiteDBDriver(connData), table: connData.MigrationTable}
+		return driver
+	})
+
+	r, err := dbrepo.NewDBRepo("SQLITE_AUDIT", dbrepo.DBConnectionData{DBName: dbrepo.SQLITE_MEMORY, MigrationTable: "audit_migration"}, nil)
+	if err != nil {
+		t.Fatalf("NewDBRepo() error = %v", err)
+	}
+	defer r.Close()
+
+	if err := r.ConnectToDB(); err != nil {
+		t.Fatalf("DBRepo.ConnectToDB() error = %v", err)
+	}
+
+	if err := r.SetupMigrationTable(); err != nil {
+		t.Fatalf("DBRepo.SetupMigrationTable() error = %v", err)
+	}
+
+	rec := models.MigrationRecord{Version: "20230101_000000", Checksum: "abc"}
+	script := models.MigrationScript{Filename: "20230101_000000_a.up.sql", SQL: "create table a (id integer);\ninsert into a values (1);"}
+	if err := r.MigrateData(rec, script, "up"); err != nil {
+		t.Fatalf("DBRepo.MigrateData() error = %v", err)
+	}
+
+	versions, err := r.MigratedVersions()
+	if err != nil || len(versions) != 1 || versions[0] != rec.Version {
+		t.Errorf("DBRepo.MigratedVersions() = %v, %v, want [%s]", versions, err, rec.Version)
+	}
+
+	if driver.addColumns == 0 || driver.splits != 1 {
+		t.Errorf("the overridden methods of the driver were called %d and %d times, want them to be used", driver.addColumns, driver.splits)
+	}
+}
@@ -0,0 +1,308 @@
+package dbrepo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Statement is a single SQL statement of a migration script. Line is the line of the script on which the statement
+// starts and Offset is the byte offset of the statement in the script
+type Statement struct {
+	SQL    string
+	Line   int
+	Offset int
+}
+
+// SQLDialect describes the lexical rules a dialect uses for quoting, comments and statement delimiters. Drivers pass
+// PostgresDialect, MySQLDialect or SQLiteDialect to SplitStatements
+type SQLDialect struct {
+	dollarQuotes       bool // Postgres $tag$ ... $tag$ bodies
+	escapeStrings      bool // Postgres E'...' strings with backslash escapes
+	nestedComments     bool // Postgres /* /* */ */ comments
+	atomicBlocks       bool // Postgres BEGIN ATOMIC ... END; function bodies
+	backslashEscapes   bool // MySQL backslash escapes in all strings
+	backticks          bool // MySQL `identifiers`
+	hashComments       bool // MySQL # comments
+	executableComments bool // MySQL /*! ... */ comments that are run as SQL
+	delimiters         bool // MySQL client DELIMITER command
+	brackets           bool // SQLite [identifiers]
+	triggerBlocks      bool // SQLite CREATE TRIGGER ... BEGIN ... END; bodies
+}
+
+// PostgresDialect is the SQL dialect of PostgreSQL
+var PostgresDialect = SQLDialect{
+	dollarQuotes:   true,
+	escapeStrings:  true,
+	nestedComments: true,
+	atomicBlocks:   true,
+}
+
+// MySQLDialect is the SQL dialect of MySQL, including the DELIMITER command of the mysql client
+var MySQLDialect = SQLDialect{
+	backslashEscapes:   true,
+	backticks:          true,
+	hashComments:       true,
+	executableComments: true,
+	delimiters:         true,
+}
+
+// SQLiteDialect is the SQL dialect of SQLite
+var SQLiteDialect = SQLDialect{
+	backticks:     true,
+	brackets:      true,
+	triggerBlocks: true,
+}
+
+// SplitStatements splits script into statements using the rules of d. Comments and white space between statements are
+// dropped, while comments inside a statement are kept. An error is returned if a quoted string, quoted identifier or
+// comment is not terminated
+func SplitStatements(script string, d SQLDialect) ([]Statement, error) {
+	var stmts []Statement
+	delimiter := ";"
+	line := 1
+	start, startLine := -1, 0
+	atLineStart := true
+	blockDepth := 0
+
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+
+		if sql := strings.TrimSpace(script[start:end]); sql != "" {
+			stmts = append(stmts, Statement{SQL: sql, Line: startLine, Offset: start})
+		}
+
+		start = -1
+	}
+
+	i := 0
+	for i < len(script) {
+		c := script[i]
+
+		switch {
+		case c == '\n':
+			line++
+			atLineStart = true
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			i++
+			continue
+		}
+
+		if d.delimiters && atLineStart && start < 0 && isDelimiterCommand(script[i:]) {
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+
+			delimiter = strings.TrimSpace(script[i+len("delimiter") : i+end])
+			if delimiter == "" {
+				return nil, fmt.Errorf("line %d - DELIMITER requires a delimiter", line)
+			}
+
+			i += end
+			continue
+		}
+
+		atLineStart = false
+
+		if strings.HasPrefix(script[i:], "--") || (d.hashComments && c == '#') {
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+
+			i += end
+			continue
+		}
+
+		if strings.HasPrefix(script[i:], "/*") {
+			end, err := commentEnd(script, i, d.nestedComments)
+			if err != nil {
+				return nil, fmt.Errorf("line %d - %w", line, err)
+			}
+
+			if d.executableComments && strings.HasPrefix(script[i:], "/*!") && start < 0 {
+				start, startLine = i, line
+			}
+
+			line += strings.Count(script[i:end], "\n")
+			i = end
+			continue
+		}
+
+		if strings.HasPrefix(script[i:], delimiter) && blockDepth == 0 {
+			flush(i)
+			i += len(delimiter)
+			continue
+		}
+
+		if start < 0 {
+			start, startLine = i, line
+		}
+
+		end := i + 1
+		switch {
+		case c == '\'':
+			backslash := d.backslashEscapes || (d.escapeStrings && isEscapeStringPrefix(script, i))
+			end = quoteEnd(script, i, backslash)
+		case c == '"':
+			end = quoteEnd(script, i, d.backslashEscapes)
+		case c == '`' && d.backticks:
+			end = quoteEnd(script, i, false)
+		case c == '$' && d.dollarQuotes && (i == 0 || !isIdentChar(script[i-1])):
+			if tag := dollarQuoteTag(script[i:]); tag != "" {
+				end = strings.Index(script[i+len(tag):], tag)
+				if end >= 0 {
+					end = i + 2*len(tag) + end
+				}
+			}
+		case c == '[' && d.brackets:
+			end = strings.IndexByte(script[i:], ']')
+			if end >= 0 {
+				end = i + end + 1
+			}
+		case (d.triggerBlocks || d.atomicBlocks) && isIdentChar(c) && (i == 0 || !isIdentChar(script[i-1])):
+			for end < len(script) && isIdentChar(script[end]) {
+				end++
+			}
+			if d.triggerBlocks {
+				blockDepth = triggerBlockDepth(script[start:i], script[i:end], blockDepth)
+			} else {
+				blockDepth = atomicBlockDepth(script[start:i], script[i:end], blockDepth)
+			}
+		}
+
+		if end < 0 {
+			return nil, fmt.Errorf("line %d - unterminated quoted string or identifier", line)
+		}
+
+		line += strings.Count(script[i:end], "\n")
+		i = end
+	}
+
+	flush(len(script))
+	return stmts, nil
+}
+
+// isDelimiterCommand reports whether s starts with the MySQL client DELIMITER command
+func isDelimiterCommand(s string) bool {
+	const cmd = "delimiter"
+	return len(s) > len(cmd) && strings.EqualFold(s[:len(cmd)], cmd) && (s[len(cmd)] == ' ' || s[len(cmd)] == '\t')
+}
+
+// commentEnd returns the index after the end of the /* comment starting at i
+func commentEnd(script string, i int, nested bool) (int, error) {
+	depth := 0
+	for j := i; j < len(script)-1; j++ {
+		switch {
+		case script[j] == '/' && script[j+1] == '*' && (nested || depth == 0):
+			depth++
+			j++
+		case script[j] == '*' && script[j+1] == '/':
+			depth--
+			j++
+			if depth == 0 {
+				return j + 1, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("unterminated comment")
+}
+
+// quoteEnd returns the index after the closing quote of the quoted string or identifier starting at i. A doubled quote
+// is part of the string. It returns -1 if the quote is not terminated
+func quoteEnd(script string, i int, backslashEscapes bool) int {
+	quote := script[i]
+	for j := i + 1; j < len(script); j++ {
+		switch script[j] {
+		case '\\':
+			if backslashEscapes {
+				j++
+			}
+		case quote:
+			if j+1 < len(script) && script[j+1] == quote {
+				j++
+				continue
+			}
+
+			return j + 1
+		}
+	}
+
+	return -1
+}
+
+// triggerBlockDepth returns the depth of the BEGIN ... END blocks after word. stmt is the statement before word. Only the
+// body of a CREATE TRIGGER statement is a block, in which CASE expressions also end with END
+func triggerBlockDepth(stmt string, word string, depth int) int {
+	switch {
+	case depth == 0 && strings.EqualFold(word, "begin") && isCreateTrigger(stmt):
+		return 1
+	case depth > 0 && strings.EqualFold(word, "case"):
+		return depth + 1
+	case depth > 0 && strings.EqualFold(word, "end"):
+		return depth - 1
+	}
+
+	return depth
+}
+
+// atomicBlockDepth returns the depth of the BEGIN ATOMIC ... END blocks after word. stmt is the statement before word.
+// The body of a SQL-standard function or procedure is a block, in which CASE expressions also end with END
+func atomicBlockDepth(stmt string, word string, depth int) int {
+	switch {
+	case depth == 0 && strings.EqualFold(word, "atomic") && endsWithWord(stmt, "begin"):
+		return 1
+	case depth > 0 && strings.EqualFold(word, "case"):
+		return depth + 1
+	case depth > 0 && strings.EqualFold(word, "end"):
+		return depth - 1
+	}
+
+	return depth
+}
+
+// endsWithWord reports whether the last word of stmt is word
+func endsWithWord(stmt string, word string) bool {
+	words := strings.Fields(stmt)
+	return len(words) > 0 && strings.EqualFold(words[len(words)-1], word)
+}
+
+// isCreateTrigger reports whether stmt starts with CREATE [TEMP | TEMPORARY] TRIGGER
+func isCreateTrigger(stmt string) bool {
+	words := strings.Fields(strings.ToLower(stmt))
+	if len(words) > 2 && (words[1] == "temp" || words[1] == "temporary") {
+		words = append(words[:1], words[2:]...)
+	}
+
+	return len(words) > 1 && words[0] == "create" && words[1] == "trigger"
+}
+
+// isEscapeStringPrefix reports whether the quote at i starts a Postgres E'...' string
+func isEscapeStringPrefix(script string, i int) bool {
+	return i > 0 && (script[i-1] == 'e' || script[i-1] == 'E') && (i == 1 || !isIdentChar(script[i-2]))
+}
+
+// dollarQuoteTag returns the $tag$ that s starts with or an empty string if s does not start with a dollar quote
+func dollarQuoteTag(s string) string {
+	for j := 1; j < len(s); j++ {
+		switch {
+		case s[j] == '$':
+			return s[:j+1]
+		case s[j] >= '0' && s[j] <= '9' && j == 1:
+			return ""
+		case !isIdentChar(s[j]):
+			return ""
+		}
+	}
+
+	return ""
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+}
@@ -0,0 +1,89 @@
+package dbrepo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_splitStatements(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		dialect SQLDialect
+		want    []Statement
+		wantErr bool
+	}{
+		{
+			name:    "simple statements",
+			script:  "create table a (id int);\n\ninsert into a values (1);\nselect 1",
+			dialect: PostgresDialect,
+			want:    []Statement{{SQL: "create table a (id int)", Line: 1}, {SQL: "insert into a values (1)", Line: 3}, {SQL: "select 1", Line: 4}},
+		},
+		{
+			name:    "comments and string literals",
+			script:  "-- dbmigrator:no-transaction\n/* a; b */\ninsert into a values ('x;y', 'it''s'); -- trailing;\ninsert into \"b;c\" values (E'\\';')",
+			dialect: PostgresDialect,
+			want:    []Statement{{SQL: "insert into a values ('x;y', 'it''s')", Line: 3}, {SQL: "insert into \"b;c\" values (E'\\';')", Line: 4}},
+		},
+		{
+			name:    "postgres dollar quotes",
+			script:  "create function f() returns int as $body$\nbegin\n  return 1;\nend;\n$body$ language plpgsql;\nselect $$a;b$$, $1;",
+			dialect: PostgresDialect,
+			want:    []Statement{{SQL: "create function f() returns int as $body$\nbegin\n  return 1;\nend;\n$body$ language plpgsql", Line: 1}, {SQL: "select $$a;b$$, $1", Line: 6}},
+		},
+		{
+			name:    "postgres begin atomic",
+			script:  "create function f(a int) returns int language sql\nbegin atomic\n  insert into b values (case when a > 0 then a end);\n  select a;\nend;\nbegin;\nselect 1;",
+			dialect: PostgresDialect,
+			want:    []Statement{{SQL: "create function f(a int) returns int language sql\nbegin atomic\n  insert into b values (case when a > 0 then a end);\n  select a;\nend", Line: 1}, {SQL: "begin", Line: 6}, {SQL: "select 1", Line: 7}},
+		},
+		{
+			name:    "postgres nested comment",
+			script:  "/* outer /* inner; */ still comment; */ select 1;",
+			dialect: PostgresDialect,
+			want:    []Statement{{SQL: "select 1", Line: 1}},
+		},
+		{
+			name:    "mysql delimiter",
+			script:  "DELIMITER $$\ncreate procedure p()\nbegin\n  select 1;\nend$$\nDELIMITER ;\n# comment;\ninsert into a values ('a\\';b', `c;d`);",
+			dialect: MySQLDialect,
+			want:    []Statement{{SQL: "create procedure p()\nbegin\n  select 1;\nend", Line: 2}, {SQL: "insert into a values ('a\\';b', `c;d`)", Line: 8}},
+		},
+		{
+			name:    "mysql executable comment",
+			script:  "/*!40101 SET NAMES utf8 */;\nselect 1;",
+			dialect: MySQLDialect,
+			want:    []Statement{{SQL: "/*!40101 SET NAMES utf8 */", Line: 1}, {SQL: "select 1", Line: 2}},
+		},
+		{
+			name:    "sqlite trigger",
+			script:  "create temp trigger t after insert on [a;b] begin\n  update c set d = case when 1 then 2 end;\n  select 1;\nend;\nbegin transaction;",
+			dialect: SQLiteDialect,
+			want:    []Statement{{SQL: "create temp trigger t after insert on [a;b] begin\n  update c set d = case when 1 then 2 end;\n  select 1;\nend", Line: 1}, {SQL: "begin transaction", Line: 5}},
+		},
+		{name: "unterminated string", script: "select 1;\nselect 'a;", dialect: PostgresDialect, wantErr: true},
+		{name: "unterminated dollar quote", script: "select $a$ b;", dialect: PostgresDialect, wantErr: true},
+		{name: "unterminated comment", script: "select 1; /* a", dialect: MySQLDialect, wantErr: true},
+		{name: "only comments", script: "-- nothing to do\n", dialect: PostgresDialect},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitStatements(tt.script, tt.dialect)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SplitStatements() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			for i, stmt := range got {
+				if tt.script[stmt.Offset:stmt.Offset+len(stmt.SQL)] != stmt.SQL {
+					t.Errorf("SplitStatements() statement %d offset %d does not point to %q", i+1, stmt.Offset, stmt.SQL)
+				}
+
+				got[i].Offset = 0
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitStatements() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package migrator
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+)
+
+// Confirmer asks a user whether a command may continue. Confirm returns true if the answer matches one of trueValues
+type Confirmer interface {
+	Confirm(prompt string, trueValues []string) (bool, error)
+}
+
+// TerminalConfirmer prompts a user on a terminal and reads the answer from in. Confirmation fails when in is not a
+// terminal so that commands run in non-interactive environments are cancelled instead of blocking
+type TerminalConfirmer struct {
+	in  *os.File
+	out io.Writer
+}
+
+// NewTerminalConfirmer creates a *TerminalConfirmer that reads answers from in and writes prompts to out
+func NewTerminalConfirmer(in *os.File, out io.Writer) *TerminalConfirmer {
+	return &TerminalConfirmer{in: in, out: out}
+}
+
+func (c *TerminalConfirmer) Confirm(prompt string, trueValues []string) (bool, error) {
+	if !isatty.IsTerminal(c.in.Fd()) && !isatty.IsCygwinTerminal(c.in.Fd()) {
+		return false, errors.New("confirmation required but input is not a terminal. Use SilentMode to run without confirmation")
+	}
+
+	Fmt_highlight.Fprintf(c.out, "%s: ", prompt)
+	answer, err := bufio.NewReader(c.in).ReadString('\n')
+	if err != nil && (err != io.EOF || answer == "") {
+		return false, err
+	}
+
+	return matchesAny(answer, trueValues), nil
+}
+
+// AlwaysYesConfirmer confirms every prompt without asking
+type AlwaysYesConfirmer struct{}
+
+func (AlwaysYesConfirmer) Confirm(prompt string, trueValues []string) (bool, error) {
+	return true, nil
+}
+
+// AlwaysNoConfirmer declines every prompt without asking
+type AlwaysNoConfirmer struct{}
+
+func (AlwaysNoConfirmer) Confirm(prompt string, trueValues []string) (bool, error) {
+	return false, nil
+}
+
+// ConfirmerFunc allows an ordinary function to be used as a Confirmer
+type ConfirmerFunc func(prompt string, trueValues []string) (bool, error)
+
+func (f ConfirmerFunc) Confirm(prompt string, trueValues []string) (bool, error) {
+	return f(prompt, trueValues)
+}
+
+// matchesAny reports whether answer matches one of values, ignoring case and surrounding white space
+func matchesAny(answer string, values []string) bool {
+	answer = strings.TrimSpace(answer)
+	for _, v := range values {
+		if strings.EqualFold(answer, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// revertPrompt returns a confirmation prompt that lists the migration versions that will be reverted by steps
+func revertPrompt(steps []PlanStep) string {
+	var sb strings.Builder
+	sb.WriteString("the following migrations will be reverted:\n")
+	for _, step := range steps {
+		if step.Direction != DIRECTION_DOWN {
+			continue
+		}
+
+		fmt.Fprintf(&sb, "  %s (%s)\n", step.Version, step.Filename)
+	}
+
+	sb.WriteString("please type 'yes' to continue or 'no' to cancel")
+	return sb.String()
+}
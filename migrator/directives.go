@@ -0,0 +1,58 @@
+package migrator
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/dhanekom/dbmigrator/models"
+)
+
+const (
+	directivePrefix = "dbmigrator:"
+
+	DIRECTIVE_NO_TRANSACTION = "no-transaction"
+	DIRECTIVE_TEMPLATE       = "template"
+)
+
+// parseMigrationScript parses the directives in the header of a migration file and returns the file as a
+// models.MigrationScript. Directives are comments in the format "-- dbmigrator:<directive>" that appear before the
+// first statement of the file, e.g. "-- dbmigrator:no-transaction" runs the migration outside a transaction and
+// "-- dbmigrator:template" renders the migration with text/template before it is run
+func parseMigrationScript(filename string, data []byte) (models.MigrationScript, error) {
+	script := models.MigrationScript{
+		Filename: filename,
+		SQL:      string(data),
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(script.SQL))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+
+		comment := strings.TrimSpace(strings.TrimPrefix(line, "--"))
+		if !strings.HasPrefix(comment, directivePrefix) {
+			continue
+		}
+
+		directive := strings.TrimSpace(strings.TrimPrefix(comment, directivePrefix))
+		switch directive {
+		case DIRECTIVE_NO_TRANSACTION:
+			script.NoTransaction = true
+		case DIRECTIVE_TEMPLATE:
+			script.Template = true
+		default:
+			return script, fmt.Errorf("%s line %d - %q is not a valid directive", filename, lineNo, directive)
+		}
+	}
+
+	return script, scanner.Err()
+}
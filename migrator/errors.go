@@ -0,0 +1,53 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrVersionNotFound is returned when the version to migrate to does not exist in the migration source
+	ErrVersionNotFound = errors.New("migration version not found")
+	// ErrGapsDetected is returned when migrating up while older migrations have not been run and out-of-order mode is
+	// disabled
+	ErrGapsDetected = errors.New("older migrations have not been run")
+	// ErrWrongDirection is returned when the command does not match the direction required to reach the to version, e.g.
+	// an up to a version lower than the current version
+	ErrWrongDirection = errors.New("wrong migration direction")
+	// ErrCancelled is returned when a command is cancelled by the user or because its context was cancelled
+	ErrCancelled = errors.New("command cancelled")
+	// ErrNoMigrations is returned by migrate and plan commands when the migration source does not contain any migrations
+	ErrNoMigrations = errors.New("no migrations found")
+	// ErrDirtyVersions is returned when a command is refused because a migration was partially applied
+	ErrDirtyVersions = errors.New("migration version(s) are dirty because a migration failed part way")
+)
+
+// CancelledError is returned when a migration run is stopped because its context was cancelled. Completed holds the
+// migration steps that completed before the run was stopped in the order that they were run
+type CancelledError struct {
+	Completed []PlanStep
+	Err       error
+}
+
+func (e *CancelledError) Error() string {
+	if len(e.Completed) == 0 {
+		return fmt.Sprintf("migration cancelled before any migrations completed - %s", e.Err)
+	}
+
+	completed := make([]string, 0, len(e.Completed))
+	for _, step := range e.Completed {
+		completed = append(completed, step.Version+" "+step.Direction)
+	}
+
+	return fmt.Sprintf("migration cancelled after completing %s - %s", strings.Join(completed, ", "), e.Err)
+}
+
+func (e *CancelledError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrCancelled so that cancelled migration runs can be detected with errors.Is
+func (e *CancelledError) Is(target error) bool {
+	return target == ErrCancelled
+}
@@ -0,0 +1,155 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dhanekom/dbmigrator/models"
+)
+
+const (
+	OUTCOME_SUCCEEDED = "succeeded"
+	OUTCOME_FAILED    = "failed"
+)
+
+// HistoryReport holds the entries of the migration history table, oldest first
+type HistoryReport struct {
+	Entries []HistoryStatus `json:"entries"`
+}
+
+// HistoryStatus describes a single migration step that was run
+type HistoryStatus struct {
+	ID          int64     `json:"id"`
+	Version     string    `json:"version"`
+	Direction   string    `json:"direction"`
+	Command     string    `json:"command"`
+	Outcome     string    `json:"outcome"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+	DBUser      string    `json:"db_user"`
+	OSUser      string    `json:"os_user"`
+	Hostname    string    `json:"hostname"`
+	ToolVersion string    `json:"tool_version,omitempty"`
+	CreatedOn   time.Time `json:"created_on"`
+}
+
+// History connects to the DB and returns the migration history entries that match filter. Every up, down, force and
+// fix step that was run is recorded, including steps that failed and migrations that were later reverted. History does
+// not create the migration tables. If they do not exist the report has no entries
+func (m *Migrator) History(filter models.HistoryFilter) (*HistoryReport, error) {
+	return m.HistoryContext(context.Background(), filter)
+}
+
+// HistoryContext is like History but uses ctx for all db operations
+func (m *Migrator) HistoryContext(ctx context.Context, filter models.HistoryFilter) (*HistoryReport, error) {
+	funcPrefix := "history"
+
+	err := m.DBRepository.ConnectToDBContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	defer func() {
+		m.DBRepository.CloseDB()
+	}()
+
+	tableExists, err := m.DBRepository.MigrationTableExistsContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	if !tableExists {
+		m.report(Event{Type: EVENT_NOTICE, Message: notInitialisedMessage})
+		return &HistoryReport{Entries: make([]HistoryStatus, 0)}, nil
+	}
+
+	entries, err := m.DBRepository.HistoryContext(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	report := HistoryReport{Entries: make([]HistoryStatus, 0, len(entries))}
+	for _, entry := range entries {
+		report.Entries = append(report.Entries, HistoryStatus{
+			ID:          entry.ID,
+			Version:     entry.Version,
+			Direction:   entry.Direction,
+			Command:     entry.Command,
+			Outcome:     entry.Outcome,
+			Error:       entry.Error,
+			DurationMs:  entry.Duration.Milliseconds(),
+			DBUser:      entry.DBUser,
+			OSUser:      entry.OSUser,
+			Hostname:    entry.Hostname,
+			ToolVersion: entry.ToolVersion,
+			CreatedOn:   entry.CreatedOn,
+		})
+	}
+
+	return &report, nil
+}
+
+// Render writes the report to w in the specified format (table or json)
+func (r HistoryReport) Render(w io.Writer, format string) error {
+	return render(w, format, r.WriteTable, r)
+}
+
+// WriteTable writes the report to w as a human readable table
+func (r HistoryReport) WriteTable(w io.Writer) error {
+	if len(r.Entries) == 0 {
+		_, err := fmt.Fprintln(w, "no history found")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tVERSION\tDIRECTION\tCOMMAND\tOUTCOME\tDURATION\tAPPLIED BY\tERROR")
+	for _, e := range r.Entries {
+		errText := "-"
+		if e.Error != "" {
+			// Only the first line is shown, errors with an SQL excerpt span several lines
+			errText, _, _ = strings.Cut(e.Error, "\n")
+		}
+
+		appliedBy := appliedByText(MigrationStatus{DBUser: e.DBUser, OSUser: e.OSUser, Hostname: e.Hostname})
+		duration := (time.Duration(e.DurationMs) * time.Millisecond).String()
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.ID, e.CreatedOn.Format("2006-01-02 15:04:05"), e.Version, e.Direction, e.Command, e.Outcome, duration, appliedBy, errText)
+	}
+
+	return tw.Flush()
+}
+
+// WriteJSON writes the report to w as indented JSON
+func (r HistoryReport) WriteJSON(w io.Writer) error {
+	return writeJSON(w, r)
+}
+
+// recordHistory adds a history entry for a step that was run by command. A failure to record the history does not
+// fail the migration and is reported as a notice instead
+func (m *Migrator) recordHistory(ctx context.Context, step PlanStep, command string, duration time.Duration, stepErr error) {
+	rec := newMigrationRecord(step, "")
+	entry := models.HistoryEntry{
+		Version:     step.Version,
+		Direction:   step.Direction,
+		Command:     command,
+		Outcome:     OUTCOME_SUCCEEDED,
+		Duration:    duration,
+		OSUser:      rec.OSUser,
+		Hostname:    rec.Hostname,
+		ToolVersion: rec.ToolVersion,
+	}
+
+	if stepErr != nil {
+		entry.Outcome = OUTCOME_FAILED
+		entry.Error = stepErr.Error()
+	}
+
+	// The history is also recorded when a run is stopped because ctx was cancelled
+	err := m.DBRepository.RecordHistoryContext(context.WithoutCancel(ctx), entry)
+	if err != nil {
+		m.report(Event{Type: EVENT_NOTICE, Version: step.Version, Message: fmt.Sprintf("could not record the history of version %s - %s", step.Version, err)})
+	}
+}
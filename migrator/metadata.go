@@ -0,0 +1,62 @@
+package migrator
+
+import (
+	"os"
+	"os/user"
+	"runtime/debug"
+
+	"github.com/dhanekom/dbmigrator/models"
+)
+
+// modulePath is the module path of dbmigrator, used to find the tool version in the build info
+const modulePath = "github.com/dhanekom/dbmigrator"
+
+// newMigrationRecord returns the details that are recorded in the migration table when step is applied
+func newMigrationRecord(step PlanStep, checksum string) models.MigrationRecord {
+	hostname, _ := os.Hostname()
+	return models.MigrationRecord{
+		Version:     step.Version,
+		Checksum:    checksum,
+		Description: step.Desc,
+		OSUser:      osUser(),
+		Hostname:    hostname,
+		ToolVersion: toolVersion(),
+	}
+}
+
+// osUser returns the name of the operating system user that runs the migrator
+func osUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+
+	return os.Getenv("USERNAME")
+}
+
+// toolVersion returns the version of the dbmigrator module that the running binary was built with
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+
+			return dep.Version
+		}
+	}
+
+	return ""
+}
@@ -1,12 +1,14 @@
 package migrator
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io/fs"
 	"math"
 	"os"
-	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
@@ -27,9 +29,12 @@ var (
 )
 
 type Migrator struct {
-	path                 string
+	source               fs.FS
 	DBRepository         *dbrepo.DBRepo
 	App                  *config.AppConfig
+	Reporter             Reporter
+	Confirmer            Confirmer
+	goMigrations         map[string]*models.MigrationVersion
 	confirmationProvided bool
 }
 
@@ -42,21 +47,34 @@ const (
 	COMMAND_VERSION = "version"
 	COMMAND_FIX     = "fix"
 	COMMAND_FORCE   = "force"
+	COMMAND_VERIFY  = "verify"
+	COMMAND_RECOVER = "recover"
+	COMMAND_HISTORY = "history"
 
 	DIRECTION_UP   = "up"
 	DIRECTION_DOWN = "down"
 )
 
 var (
-	re = regexp.MustCompile(`^(\d{8}_\d{6})_(\w+)\.(down|up)\.sql$`)
+	re          = regexp.MustCompile(`^(\d{8}_\d{6})_(\w+)\.(down|up)\.sql$`)
+	reVersion   = regexp.MustCompile(`^\d{8}_\d{6}$`)
+	reGoMigDesc = regexp.MustCompile(`^\w+$`)
 )
 
-// NewMigrator creates a *Migrator that can migrate a DB to different migration versions
-func NewMigrator(path string, db *dbrepo.DBRepo, a *config.AppConfig) (*Migrator, error) {
+// NewMigrator creates a *Migrator that can migrate a DB to different migration versions. Migration files are read from
+// source, e.g. a *DirSource for a directory on disk or an embed.FS for migrations that are embedded with go:embed
+func NewMigrator(source fs.FS, db *dbrepo.DBRepo, a *config.AppConfig) (*Migrator, error) {
+	if source == nil {
+		return nil, errors.New("NewMigrator - a migration source is required")
+	}
+
 	result := Migrator{
-		path:                 path,
+		source:               source,
 		DBRepository:         db,
 		App:                  a,
+		Reporter:             NewColorReporter(os.Stdout),
+		Confirmer:            NewTerminalConfirmer(os.Stdin, os.Stdout),
+		goMigrations:         make(map[string]*models.MigrationVersion),
 		confirmationProvided: false,
 	}
 
@@ -71,32 +89,39 @@ func (m *Migrator) GetConfirmation(promptMsg string, trueValues []string) error
 		return nil
 	}
 
-	var answer string
-	Fmt_highlight.Printf("%s: ", promptMsg)
-	_, err := fmt.Scanf("%s", &answer)
-	if err != nil || answer != "yes" {
-		return errors.New("command cancelled")
+	confirmer := m.Confirmer
+	if confirmer == nil {
+		confirmer = NewTerminalConfirmer(os.Stdin, os.Stdout)
+	}
+
+	confirmed, err := confirmer.Confirm(promptMsg, trueValues)
+	if err != nil {
+		return fmt.Errorf("%w - %w", ErrCancelled, err)
+	}
+
+	if !confirmed {
+		return ErrCancelled
 	}
 
 	m.confirmationProvided = true
 	return nil
 }
 
-// Create creates an up and down migration file in the configured migration directory
+// Create creates an up and down migration file in the configured migration directory. The migration source must be
+// a WritableFS
 func (m Migrator) Create(desc string) error {
 	funcPrefix := "create"
 
+	source, ok := m.source.(WritableFS)
+	if !ok {
+		return errors.New(funcPrefix + " - the migration source is read-only")
+	}
+
 	desc = strings.ToLower(desc)
 	if desc == "" {
 		return errors.New(funcPrefix + " - a description is required")
 	}
 
-	// Check if Path exists and create dir if it does not exist
-	_, err := os.Stat(m.path)
-	if os.IsNotExist(err) {
-		os.MkdirAll(m.path, 0666)
-	}
-
 	// Generate up and down file names in the following format yyyymmddhhnnss_descriptions
 	var sb strings.Builder
 	addUnderscore := false
@@ -121,9 +146,10 @@ func (m Migrator) Create(desc string) error {
 	t := time.Now()
 	desc = t.Format("20060102_150405_") + desc
 
+	// The migration directory is created with the first migration files
 	mvs, err := m.GetMigrationVersionInfoMap()
-	if err != nil {
-		return fmt.Errorf(funcPrefix+" - %s", err)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf(funcPrefix+" - %w", err)
 	}
 
 	for _, mv := range mvs {
@@ -132,34 +158,28 @@ func (m Migrator) Create(desc string) error {
 		}
 	}
 
-	tmpFilepath := filepath.Join(m.path, desc+".up.sql")
-	fmt.Printf("creating %s\n", tmpFilepath)
-	file, err := os.Create(tmpFilepath)
-	if err != nil {
-		return fmt.Errorf("create - %s", err)
-	}
-	file.Close()
-
-	tmpFilepath = filepath.Join(m.path, desc+".down.sql")
-	fmt.Printf("creating %s\n", tmpFilepath)
-	file, err = os.Create(tmpFilepath)
-	if err != nil {
-		return fmt.Errorf("create - %s", err)
+	for _, direction := range []string{DIRECTION_UP, DIRECTION_DOWN} {
+		filename := desc + "." + direction + ".sql"
+		m.report(Event{Type: EVENT_FILE_CREATED, Filename: sourcePath(source, filename)})
+		err = source.WriteFile(filename, nil)
+		if err != nil {
+			return fmt.Errorf("create - %w", err)
+		}
 	}
-	file.Close()
 
 	return nil
 }
 
 // GetMigrationVersionInfoMap reads all files in the migration directory and parses the filenames to determine
-// all the migration vesions and descriptions. There details are return in a map of models.MigrationVersion items
+// all the migration vesions and descriptions. There details are return in a map of models.MigrationVersion items.
+// Registered Go migrations are merged with the migration files
 func (m Migrator) GetMigrationVersionInfoMap() (map[string]*models.MigrationVersion, error) {
 	funcPrefix := "GetMigrationVersionInfoMap"
 
 	mvs := make(map[string]*models.MigrationVersion, 0)
-	files, err := ioutil.ReadDir(m.path)
+	files, err := fs.ReadDir(m.source, ".")
 	if err != nil {
-		return nil, fmt.Errorf(funcPrefix+" - getting migration filenames - %s", err)
+		return nil, fmt.Errorf(funcPrefix+" - getting migration filenames - %w", err)
 	}
 
 	for _, file := range files {
@@ -195,38 +215,119 @@ func (m Migrator) GetMigrationVersionInfoMap() (map[string]*models.MigrationVers
 		}
 	}
 
+	for _, goMigration := range m.goMigrations {
+		mv, ok := mvs[goMigration.Version]
+		if !ok {
+			mvs[goMigration.Version] = &models.MigrationVersion{
+				Version:  goMigration.Version,
+				Desc:     goMigration.Desc,
+				UpFunc:   goMigration.UpFunc,
+				DownFunc: goMigration.DownFunc,
+			}
+
+			continue
+		}
+
+		if mv.Desc != goMigration.Desc {
+			return nil, fmt.Errorf(funcPrefix+" - Go migration %s_%s does not match the description of migration file version %s_%s", goMigration.Version, goMigration.Desc, mv.Version, mv.Desc)
+		}
+
+		if (mv.UpFileExists && goMigration.UpFunc != nil) || (mv.DownFileExists && goMigration.DownFunc != nil) {
+			return nil, fmt.Errorf(funcPrefix+" - migration version %s has both a migration file and a Go migration for the same direction", mv.Version)
+		}
+
+		mv.UpFunc = goMigration.UpFunc
+		mv.DownFunc = goMigration.DownFunc
+	}
+
 	return mvs, nil
 }
 
+// RegisterGoMigration registers Go functions that migrate a db up and down to version. Go migrations are ordered,
+// listed and run exactly like migration files. A version can have a migration file for one direction and a Go
+// migration for the other direction, but then desc must match the description of the file
+func (m *Migrator) RegisterGoMigration(version, desc string, up, down models.MigrationFunc) error {
+	funcPrefix := "registerGoMigration"
+
+	if !reVersion.MatchString(version) {
+		return fmt.Errorf(funcPrefix+" - %q is not a valid migration version. The format must be yyyymmdd_hhnnss", version)
+	}
+
+	if !reGoMigDesc.MatchString(desc) {
+		return fmt.Errorf(funcPrefix+" - %q is not a valid migration description", desc)
+	}
+
+	if up == nil && down == nil {
+		return fmt.Errorf(funcPrefix+" - version %s requires an up or down function", version)
+	}
+
+	if _, ok := m.goMigrations[version]; ok {
+		return fmt.Errorf(funcPrefix+" - a Go migration is already registered for version %s", version)
+	}
+
+	if m.goMigrations == nil {
+		m.goMigrations = make(map[string]*models.MigrationVersion)
+	}
+
+	m.goMigrations[version] = &models.MigrationVersion{
+		Version:  version,
+		Desc:     desc,
+		UpFunc:   up,
+		DownFunc: down,
+	}
+
+	return nil
+}
+
 // GetMigrationVersionInfo gathers details of all migrated versions and migrations files
 func (m Migrator) GetMigrationVersionInfo() ([]models.MigrationVersion, error) {
-	funcPrefix := "getMigrationVersionInfo"
+	return m.GetMigrationVersionInfoContext(context.Background())
+}
 
-	result := make([]models.MigrationVersion, 0)
+// GetMigrationVersionInfoContext is like GetMigrationVersionInfo but uses ctx for all db operations
+func (m Migrator) GetMigrationVersionInfoContext(ctx context.Context) ([]models.MigrationVersion, error) {
+	funcPrefix := "getMigrationVersionInfo"
 
 	mvs, err := m.GetMigrationVersionInfoMap()
 	if err != nil {
-		return nil, fmt.Errorf(funcPrefix+" - %s", err)
+		return nil, fmt.Errorf(funcPrefix+" - %w", err)
 	}
 
-	migratedVersions, err := m.DBRepository.MigratedVersions()
+	appliedMigrations, err := m.DBRepository.AppliedMigrationsContext(ctx)
 	if err != nil {
-		return nil, fmt.Errorf(funcPrefix+" - %s", err)
+		return nil, fmt.Errorf(funcPrefix+" - %w", err)
 	}
 
-	for _, migratedVersion := range migratedVersions {
-		mv, ok := mvs[migratedVersion]
+	for _, am := range appliedMigrations {
+		mv, ok := mvs[am.Version]
 		if !ok {
 			mv = &models.MigrationVersion{
-				Version: migratedVersion,
+				Version: am.Version,
 			}
 
-			mvs[migratedVersion] = mv
-		} else {
-			mv.ExistsInDB = true
+			mvs[am.Version] = mv
+		}
+
+		mv.ExistsInDB = true
+		mv.AppliedAt = am.CreatedOn
+		mv.Checksum = am.Checksum
+		mv.Dirty = am.Dirty
+		mv.Duration = am.Duration
+		mv.DBUser = am.DBUser
+		mv.OSUser = am.OSUser
+		mv.Hostname = am.Hostname
+		mv.ToolVersion = am.ToolVersion
+		if mv.Desc == "" {
+			mv.Desc = am.Description
 		}
 	}
 
+	return sortMigrationVersions(mvs), nil
+}
+
+// sortMigrationVersions returns the migration versions in mvs as a slice sorted by version
+func sortMigrationVersions(mvs map[string]*models.MigrationVersion) []models.MigrationVersion {
+	result := make([]models.MigrationVersion, 0, len(mvs))
 	for _, v := range mvs {
 		result = append(result, *v)
 	}
@@ -235,7 +336,13 @@ func (m Migrator) GetMigrationVersionInfo() ([]models.MigrationVersion, error) {
 		return result[i].Version < result[j].Version
 	})
 
-	return result, nil
+	return result
+}
+
+// checksum returns the hex encoded SHA-256 checksum of data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
 
 // GetMigrationsToRun determines which migrations must be run and returns the result as a slice of models.MigrationVersion
@@ -244,9 +351,9 @@ func (m Migrator) GetMigrationsToRun(mvs []models.MigrationVersion, currentVersi
 	result := make([]models.MigrationVersion, 0)
 
 	if migrationDirection == DIRECTION_UP && currentVersion >= toVersion {
-		return result, fmt.Errorf(funcPrefix + " - to version must be higher than the current version")
+		return result, fmt.Errorf(funcPrefix+" - to version must be higher than the current version - %w", ErrWrongDirection)
 	} else if migrationDirection == DIRECTION_DOWN && toVersion >= currentVersion {
-		return result, fmt.Errorf(funcPrefix + " - to version must be lower than the current version")
+		return result, fmt.Errorf(funcPrefix+" - to version must be lower than the current version - %w", ErrWrongDirection)
 	}
 
 	if migrationDirection == DIRECTION_UP {
@@ -290,75 +397,170 @@ func (m *Migrator) FindMigrationGaps(mvs []models.MigrationVersion, currentVersi
 	return
 }
 
-// Migrate migrates a db from the current version to the specified toVersion
+// Migrate migrates a db from the current version to the specified toVersion. ErrNoMigrations is returned if the migration
+// source is empty
 func (m *Migrator) Migrate(command, toVersion string) error {
+	return m.MigrateContext(context.Background(), command, toVersion)
+}
+
+// MigrateContext is like Migrate but uses ctx for all db operations
+func (m *Migrator) MigrateContext(ctx context.Context, command, toVersion string) error {
 	funcPrefix := "migrate"
-	var msg string
-	var NoOfMigrations int = 0
-	var err error
 
-	if command != COMMAND_UP && command != COMMAND_DOWN && command != COMMAND_GOTO && command != COMMAND_FORCE {
-		return fmt.Errorf(funcPrefix+" - %q is not a valid migration command", command)
+	noOfMigrations, err := parseMigrationArgs(command, toVersion)
+	if err != nil {
+		return fmt.Errorf(funcPrefix+" - %w", err)
 	}
 
-	if (command == COMMAND_UP || command == COMMAND_DOWN) && toVersion != "" {
-		NoOfMigrations, err = strconv.Atoi(toVersion)
-		if err != nil || NoOfMigrations < 1 || NoOfMigrations > 9999999 {
-			return errors.New(funcPrefix + " - a valid number of migrations [N] is required")
+	err = m.withLock(ctx, func() error {
+		mvs, err := m.GetMigrationVersionInfoContext(ctx)
+		if err != nil {
+			return err
 		}
 
-		NoOfMigrations = int(math.Abs(float64(NoOfMigrations)))
-		if command == COMMAND_DOWN {
-			NoOfMigrations = NoOfMigrations * -1
+		// Get current version from db
+		currentVersion, err := m.DBRepository.CurrentVersionContext(ctx)
+		if err != nil {
+			return err
 		}
-	}
 
-	if (command == COMMAND_GOTO || command == COMMAND_FORCE) && toVersion == "" {
-		return fmt.Errorf(funcPrefix+" - the %s command requires a to version to be specified", command)
+		plan, err := m.resolveMigration(mvs, currentVersion, command, toVersion, noOfMigrations)
+		if err != nil {
+			return err
+		}
+
+		if plan.ToVersion == plan.CurrentVersion && len(plan.Steps) == 0 {
+			m.report(Event{Type: EVENT_COMPLETED, Message: "db already migrated to the newest version"})
+			return nil
+		}
+
+		if n := plan.OutOfOrderCount(); n > 0 {
+			m.report(Event{Type: EVENT_NOTICE, Message: fmt.Sprintf("running %d out-of-order migration(s) older than the current version %s", n, plan.CurrentVersion)})
+		}
+
+		if command == COMMAND_UP && toVersion == "" {
+			m.report(Event{Type: EVENT_NOTICE, Message: fmt.Sprintf("migrating up to version %s", plan.ToVersion)})
+		}
+
+		if command != COMMAND_FORCE && len(plan.Steps) > 0 && plan.Direction == DIRECTION_DOWN && !m.confirmationProvided {
+			err := m.GetConfirmation(revertPrompt(plan.Steps), []string{"yes"})
+			if err != nil {
+				return err
+			}
+		}
+
+		err = m.runMigrations(ctx, plan.Steps, command)
+		if err != nil {
+			return err
+		}
+
+		if command == COMMAND_FORCE {
+			m.report(Event{Type: EVENT_FORCE_APPLIED, Version: plan.ToVersion, Direction: plan.Direction})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf(funcPrefix+" - %w", err)
 	}
 
-	err = m.DBRepository.ConnectToDB()
+	return nil
+}
+
+// withLock connects to the db, acquires the migration lock and sets up the migration table before calling fn. The lock
+// is released and the db connection closed when fn returns
+func (m *Migrator) withLock(ctx context.Context, fn func() error) (err error) {
+	err = m.DBRepository.ConnectToDBContext(ctx)
 	if err != nil {
-		return fmt.Errorf(funcPrefix+" - %s", err)
+		return err
 	}
 
 	defer func() {
 		m.DBRepository.CloseDB()
 	}()
 
-	err = m.DBRepository.SetupMigrationTable()
+	// Only one migrator may change the db at a time
+	err = m.DBRepository.LockContext(ctx)
 	if err != nil {
-		return fmt.Errorf(funcPrefix+" - %s", err)
+		return err
 	}
 
-	mvs, err := m.GetMigrationVersionInfo()
+	defer func() {
+		err = errors.Join(err, m.DBRepository.Unlock())
+	}()
+
+	err = m.DBRepository.SetupMigrationTableContext(ctx)
 	if err != nil {
-		return fmt.Errorf(funcPrefix+" - %s", err)
+		return err
+	}
+
+	return fn()
+}
+
+// parseMigrationArgs validates the arguments of a migration command and returns the number of migrations that must be
+// run for the up and down commands. A negative number is returned for down migrations and 0 if all migrations must be run
+func parseMigrationArgs(command, toVersion string) (int, error) {
+	var noOfMigrations int
+
+	if command != COMMAND_UP && command != COMMAND_DOWN && command != COMMAND_GOTO && command != COMMAND_FORCE {
+		return 0, fmt.Errorf("%q is not a valid migration command", command)
+	}
+
+	if (command == COMMAND_UP || command == COMMAND_DOWN) && toVersion != "" {
+		var err error
+		noOfMigrations, err = strconv.Atoi(toVersion)
+		if err != nil || noOfMigrations < 1 || noOfMigrations > 9999999 {
+			return 0, errors.New("a valid number of migrations [N] is required")
+		}
+
+		noOfMigrations = int(math.Abs(float64(noOfMigrations)))
+		if command == COMMAND_DOWN {
+			noOfMigrations = noOfMigrations * -1
+		}
 	}
 
+	if (command == COMMAND_GOTO || command == COMMAND_FORCE) && toVersion == "" {
+		return 0, fmt.Errorf("the %s command requires a to version to be specified", command)
+	}
+
+	return noOfMigrations, nil
+}
+
+// resolveMigration refuses to migrate when there are no migrations or when applied migrations are dirty or were
+// modified, and otherwise resolves the plan of the migration command with resolvePlan. Migrate and Plan both use it,
+// so that a plan contains exactly the steps that migrate would run
+func (m *Migrator) resolveMigration(mvs []models.MigrationVersion, currentVersion, command, toVersion string, noOfMigrations int) (*MigrationPlan, error) {
 	if len(mvs) == 0 {
-		msg = "no migrations found"
-		Fmt_highlight.Println(msg)
-		return nil
+		return nil, ErrNoMigrations
 	}
 
-	// Get current version from db
-	currentVersion, err := m.DBRepository.CurrentVersion()
+	err := checkDirtyVersions(mvs)
 	if err != nil {
-		return fmt.Errorf(funcPrefix+" - %s", err)
+		return nil, err
 	}
 
-	if NoOfMigrations != 0 {
+	err = m.checkModifiedMigrations(mvs)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.resolvePlan(mvs, currentVersion, command, toVersion, noOfMigrations)
+}
+
+// resolvePlan determines the version that the db must be migrated to, the migration direction and the migrations that must
+// be run. No changes are made to the db
+func (m *Migrator) resolvePlan(mvs []models.MigrationVersion, currentVersion, command, toVersion string, noOfMigrations int) (*MigrationPlan, error) {
+	if noOfMigrations != 0 {
 		toVersion = ""
-		if NoOfMigrations > 0 {
+		if noOfMigrations > 0 {
 			for i := 0; i <= len(mvs)-1; i++ {
 				if mvs[i].Version <= currentVersion {
 					continue
 				}
 
 				toVersion = mvs[i].Version
-				NoOfMigrations = NoOfMigrations - 1
-				if NoOfMigrations <= 0 {
+				noOfMigrations = noOfMigrations - 1
+				if noOfMigrations <= 0 {
 					break
 				}
 			}
@@ -369,8 +571,8 @@ func (m *Migrator) Migrate(command, toVersion string) error {
 				}
 
 				toVersion = mvs[i].Version
-				NoOfMigrations = NoOfMigrations + 1
-				if NoOfMigrations >= 0 {
+				noOfMigrations = noOfMigrations + 1
+				if noOfMigrations >= 0 {
 					break
 				}
 
@@ -383,10 +585,8 @@ func (m *Migrator) Migrate(command, toVersion string) error {
 
 	if toVersion == "" && command == COMMAND_UP {
 		for i := len(mvs) - 1; i >= 0; i-- {
-			if mvs[i].FileExists(command) {
+			if mvs[i].HasMigration(command) {
 				toVersion = mvs[i].Version
-				msg = fmt.Sprintf("migrating up to version %s", toVersion)
-				Fmt_highlight.Println(msg)
 				break
 			}
 		}
@@ -404,9 +604,10 @@ func (m *Migrator) Migrate(command, toVersion string) error {
 	}
 
 	if !found {
-		return fmt.Errorf(funcPrefix+" - migration version %s not found", toVersion)
+		return nil, fmt.Errorf("%w - %s", ErrVersionNotFound, toVersion)
 	}
 
+	// Determine migration direction. If e.g. version > current version then an up is required
 	var migrationDirection string
 	if toVersion > currentVersion {
 		migrationDirection = DIRECTION_UP
@@ -414,20 +615,38 @@ func (m *Migrator) Migrate(command, toVersion string) error {
 		migrationDirection = DIRECTION_DOWN
 	}
 
+	plan := MigrationPlan{
+		Command:        command,
+		CurrentVersion: currentVersion,
+		ToVersion:      toVersion,
+		Direction:      migrationDirection,
+		Steps:          make([]PlanStep, 0),
+	}
+
+	// Older migrations that have not been run are only allowed in out-of-order mode. They are run in version order
+	// before the newer migrations
+	var outOfOrderMigrations []models.MigrationVersion
 	if command != COMMAND_FORCE && toVersion >= currentVersion {
 		migrationGaps, _ := m.FindMigrationGaps(mvs, currentVersion)
-		if len(migrationGaps) > 0 {
-			return fmt.Errorf(funcPrefix + " - up migrations not allowed when all older migrations have not been run")
+		if len(migrationGaps) > 0 && !m.App.AllowOutOfOrder {
+			return nil, fmt.Errorf("up migrations not allowed - %w", ErrGapsDetected)
 		}
-	}
 
-	if toVersion == currentVersion {
-		msg = "db already migrated to the newest version"
-		Fmt_success.Println(msg)
-		return nil
+		for _, mv := range mvs {
+			if _, ok := migrationGaps[mv.Version]; ok {
+				outOfOrderMigrations = append(outOfOrderMigrations, mv)
+			}
+		}
+
+		if len(outOfOrderMigrations) > 0 {
+			migrationDirection = DIRECTION_UP
+			plan.Direction = DIRECTION_UP
+		}
 	}
 
-	// Determine migration direction. If e.g. version > current version then an up is required
+	if toVersion == currentVersion && len(outOfOrderMigrations) == 0 {
+		return &plan, nil
+	}
 
 	if command == COMMAND_UP || command == COMMAND_DOWN {
 		var commandDirection string
@@ -439,56 +658,257 @@ func (m *Migrator) Migrate(command, toVersion string) error {
 
 		if commandDirection != migrationDirection {
 			if command == COMMAND_UP {
-				return fmt.Errorf(funcPrefix+" - up migration not allowed because the current db version (%s) is higher than %s", currentVersion, toVersion)
+				return nil, fmt.Errorf("up migration not allowed because the current db version (%s) is higher than %s - %w", currentVersion, toVersion, ErrWrongDirection)
 			} else if command == COMMAND_DOWN {
-				return fmt.Errorf(funcPrefix+" - down migration not allowed because the current db version (%s) is lower than %s", currentVersion, toVersion)
+				return nil, fmt.Errorf("down migration not allowed because the current db version (%s) is lower than %s - %w", currentVersion, toVersion, ErrWrongDirection)
 			}
 		}
 	}
 
 	// Find all migration files between the current version (excluded) and the new version (included)
-	migrationsToRun, err := m.GetMigrationsToRun(mvs, currentVersion, toVersion, migrationDirection, command)
-	if err != nil {
-		return fmt.Errorf(funcPrefix+" - %s", err)
+	var migrationsToRun []models.MigrationVersion
+	var err error
+	if toVersion != currentVersion {
+		migrationsToRun, err = m.GetMigrationsToRun(mvs, currentVersion, toVersion, migrationDirection, command)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if command != COMMAND_FORCE && len(migrationsToRun) > 0 && migrationDirection == DIRECTION_DOWN && !m.confirmationProvided {
-		err := m.GetConfirmation(`please type 'yes' to continue or 'no' to cancel`, []string{"yes"})
+	migrations := append(outOfOrderMigrations, migrationsToRun...)
+
+	// Versions that are in the db without migration files can not be migrated. This is checked before any migrations
+	// are run so that the db is not left partially migrated
+	if command != COMMAND_FORCE {
+		err = checkMigrationFiles(migrations, migrationDirection)
 		if err != nil {
-			return fmt.Errorf(funcPrefix+" - %s", err)
+			return nil, err
+		}
+	}
+
+	plan.Steps, err = m.planSteps(migrations, migrationDirection, command)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range outOfOrderMigrations {
+		plan.Steps[i].OutOfOrder = true
+	}
+
+	return &plan, nil
+}
+
+// checkMigrationFiles returns an error for the first version in mvs without a migration file or Go migration for the
+// migration direction
+func checkMigrationFiles(mvs []models.MigrationVersion, migrationDirection string) error {
+	for _, mv := range mvs {
+		if !mv.HasMigration(migrationDirection) {
+			return fmt.Errorf("%s migration file for version %s not found", migrationDirection, mv.Version)
 		}
 	}
 
-	if command == COMMAND_FORCE {
-		msg = fmt.Sprintf("forcing current version to %s", toVersion)
-		Fmt_highlight.Print(msg)
+	return nil
+}
+
+// report passes e to the configured Reporter
+func (m Migrator) report(e Event) {
+	if m.Reporter != nil {
+		m.Reporter.Report(e)
+	}
+}
+
+// planSteps returns a PlanStep for each of the migrations in mvs
+func (m *Migrator) planSteps(mvs []models.MigrationVersion, migrationDirection, command string) ([]PlanStep, error) {
+	statement, err := m.DBRepository.MigrateDBStatement(migrationDirection)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := make([]PlanStep, 0, len(mvs))
+	for _, mv := range mvs {
+		step := PlanStep{
+			Version:   mv.Version,
+			Desc:      mv.Desc,
+			Direction: migrationDirection,
+			Statement: statement,
+		}
+
+		if command != COMMAND_FORCE && mv.Func(migrationDirection) != nil {
+			step.Filename = mv.GoName(migrationDirection)
+			step.GoMigration = true
+		} else if command != COMMAND_FORCE {
+			step.Filename = mv.Filename(migrationDirection)
+		}
+
+		steps = append(steps, step)
 	}
 
-	for _, mv := range migrationsToRun {
+	return steps, nil
+}
+
+// runMigrations runs the migration steps in order. When the force command is used only the migration versions are
+// updated and no migration files are run. If ctx is cancelled the remaining steps are skipped and a *CancelledError
+// listing the completed steps is returned
+func (m *Migrator) runMigrations(ctx context.Context, steps []PlanStep, command string) error {
+	completed := make([]PlanStep, 0, len(steps))
+	for _, step := range steps {
+		if ctx.Err() != nil {
+			return &CancelledError{Completed: completed, Err: ctx.Err()}
+		}
+
 		if command == COMMAND_FORCE {
-			err = m.DBRepository.MigrateDB(mv.Version, migrationDirection)
-			if err != nil {
-				return fmt.Errorf(funcPrefix+" - %s", err)
-			}
-		} else {
-			data, err := ioutil.ReadFile(filepath.Join(m.path, mv.Filename(migrationDirection)))
+			// The checksum of forced versions is unknown because their migration files are not run
+			err := m.DBRepository.MigrateDBContext(ctx, newMigrationRecord(step, ""), step.Direction)
+			m.recordHistory(ctx, step, command, 0, err)
 			if err != nil {
-				return fmt.Errorf(funcPrefix+" - %s", err)
+				return m.migrationError(ctx, completed, err)
 			}
 
-			msg = fmt.Sprintf("running %s migration %s", migrationDirection, mv.Filename(migrationDirection))
-			fmt.Print(msg)
-			err = m.DBRepository.MigrateData(mv.Version, string(data), migrationDirection)
-			if err != nil {
-				Fmt_error.Println(" - failed")
-				return fmt.Errorf(funcPrefix+" - %s", err)
-			}
-			Fmt_success.Println(" - success")
+			completed = append(completed, step)
+			continue
+		}
+
+		event := Event{Version: step.Version, Direction: step.Direction, Filename: step.Filename}
+		event.Type = EVENT_MIGRATION_STARTED
+		m.report(event)
+
+		start := time.Now()
+		err := m.runStep(ctx, step)
+		event.Duration = time.Since(start)
+		m.recordHistory(ctx, step, command, event.Duration, err)
+		if err != nil {
+			event.Type, event.Err = EVENT_MIGRATION_FAILED, err
+			m.report(event)
+			return m.migrationError(ctx, completed, err)
 		}
+
+		event.Type = EVENT_MIGRATION_SUCCEEDED
+		m.report(event)
+
+		completed = append(completed, step)
+	}
+
+	return nil
+}
+
+// runStep runs the migration file or Go migration of a single step
+func (m *Migrator) runStep(ctx context.Context, step PlanStep) error {
+	if step.GoMigration {
+		goMigration, ok := m.goMigrations[step.Version]
+		if !ok || goMigration.Func(step.Direction) == nil {
+			return fmt.Errorf("%s Go migration for version %s is not registered", step.Direction, step.Version)
+		}
+
+		rec := newMigrationRecord(step, "")
+		return m.DBRepository.MigrateFuncContext(ctx, rec, goMigration.Func(step.Direction), step.Direction)
 	}
 
-	if command == COMMAND_FORCE {
-		Fmt_success.Println(" - success")
+	script, data, err := m.readMigrationScript(step.Filename)
+	if err != nil {
+		return err
+	}
+
+	rec := newMigrationRecord(step, checksum(data))
+	return m.DBRepository.MigrateDataContext(ctx, rec, script, step.Direction)
+}
+
+// migrationError returns a *CancelledError if a migration failed because ctx was cancelled and err otherwise
+func (m *Migrator) migrationError(ctx context.Context, completed []PlanStep, err error) error {
+	if ctx.Err() != nil {
+		return &CancelledError{Completed: completed, Err: err}
+	}
+
+	return err
+}
+
+// Fix resolves migration gaps by migrating the DB down to the last valid version (the last version that was migrated
+// before the oldest migration gap) and then migrating up again to the version that was current before the fix
+func (m *Migrator) Fix() error {
+	return m.FixContext(context.Background())
+}
+
+// FixContext is like Fix but uses ctx for all db operations
+func (m *Migrator) FixContext(ctx context.Context) error {
+	funcPrefix := "fix"
+
+	if !m.App.AllowFix {
+		return errors.New(funcPrefix + " - the fix command is not allowed. Set AllowFix to enable it")
+	}
+
+	err := m.withLock(ctx, func() error {
+		mvs, err := m.GetMigrationVersionInfoContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = checkDirtyVersions(mvs)
+		if err != nil {
+			return err
+		}
+
+		// Fix re-applies up migration files, so modified files are refused like they are by migrate
+		err = m.checkModifiedMigrations(mvs)
+		if err != nil {
+			return err
+		}
+
+		currentVersion, err := m.DBRepository.CurrentVersionContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		migrationGaps, lastValidVersion := m.FindMigrationGaps(mvs, currentVersion)
+		if len(migrationGaps) == 0 {
+			m.report(Event{Type: EVENT_COMPLETED, Message: "no migration gaps found"})
+			return nil
+		}
+
+		downMigrations, err := m.GetMigrationsToRun(mvs, currentVersion, lastValidVersion, DIRECTION_DOWN, COMMAND_FIX)
+		if err != nil {
+			return err
+		}
+
+		upMigrations, err := m.GetMigrationsToRun(mvs, lastValidVersion, currentVersion, DIRECTION_UP, COMMAND_FIX)
+		if err != nil {
+			return err
+		}
+
+		// Make sure that all the required migration files exist before any migrations are run
+		err = checkMigrationFiles(downMigrations, DIRECTION_DOWN)
+		if err != nil {
+			return err
+		}
+
+		err = checkMigrationFiles(upMigrations, DIRECTION_UP)
+		if err != nil {
+			return err
+		}
+
+		downSteps, err := m.planSteps(downMigrations, DIRECTION_DOWN, COMMAND_FIX)
+		if err != nil {
+			return err
+		}
+
+		upSteps, err := m.planSteps(upMigrations, DIRECTION_UP, COMMAND_FIX)
+		if err != nil {
+			return err
+		}
+
+		m.report(Event{Type: EVENT_NOTICE, Message: fmt.Sprintf("fixing %d migration gap(s) by migrating down to version %q and up to version %s", len(migrationGaps), lastValidVersion, currentVersion)})
+		err = m.GetConfirmation(revertPrompt(downSteps), []string{"yes"})
+		if err != nil {
+			return err
+		}
+
+		err = m.runMigrations(ctx, append(downSteps, upSteps...), COMMAND_FIX)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf(funcPrefix+" - %w", err)
 	}
 
 	return nil
@@ -499,26 +919,110 @@ func (m Migrator) Up(toVersion string) error {
 	return m.Migrate(COMMAND_UP, toVersion)
 }
 
+// UpContext is like Up but uses ctx for all db operations
+func (m Migrator) UpContext(ctx context.Context, toVersion string) error {
+	return m.MigrateContext(ctx, COMMAND_UP, toVersion)
+}
+
 // Down migrates a DB down for N number of migrations
 func (m Migrator) Down(toVersion string) error {
 	return m.Migrate(COMMAND_DOWN, toVersion)
 }
 
+// DownContext is like Down but uses ctx for all db operations
+func (m Migrator) DownContext(ctx context.Context, toVersion string) error {
+	return m.MigrateContext(ctx, COMMAND_DOWN, toVersion)
+}
+
 // Goto migrates a DB to the migration specified by version
 func (m Migrator) Goto(toVersion string) error {
 	return m.Migrate(COMMAND_GOTO, toVersion)
 }
 
+// GotoContext is like Goto but uses ctx for all db operations
+func (m Migrator) GotoContext(ctx context.Context, toVersion string) error {
+	return m.MigrateContext(ctx, COMMAND_GOTO, toVersion)
+}
+
 // Force sets the current migration version without running any migrations
 func (m Migrator) Force(toVersion string) error {
 	return m.Migrate(COMMAND_FORCE, toVersion)
 }
 
+// ForceContext is like Force but uses ctx for all db operations
+func (m Migrator) ForceContext(ctx context.Context, toVersion string) error {
+	return m.MigrateContext(ctx, COMMAND_FORCE, toVersion)
+}
+
+// Recover clears the dirty mark of a migration version that was partially applied, after the db was repaired
+// manually. Direction up marks the version as applied and direction down marks it as rolled back
+func (m *Migrator) Recover(version, migrationDirection string) error {
+	return m.RecoverContext(context.Background(), version, migrationDirection)
+}
+
+// RecoverContext is like Recover but uses ctx for all db operations
+func (m *Migrator) RecoverContext(ctx context.Context, version, migrationDirection string) error {
+	funcPrefix := "recover"
+
+	migrationDirection = strings.ToLower(migrationDirection)
+	if migrationDirection != DIRECTION_UP && migrationDirection != DIRECTION_DOWN {
+		return fmt.Errorf(funcPrefix+" - %q is not a valid direction - %w", migrationDirection, dbrepo.ErrInvalidDirection)
+	}
+
+	err := m.withLock(ctx, func() error {
+		state := "applied"
+		if migrationDirection == DIRECTION_DOWN {
+			state = "rolled back"
+		}
+
+		err := m.GetConfirmation(fmt.Sprintf("version %s will be marked as %s. Only continue if the db has been repaired manually\nplease type 'yes' to continue or 'no' to cancel", version, state), []string{"yes"})
+		if err != nil {
+			return err
+		}
+
+		err = m.DBRepository.RecoverContext(ctx, version, migrationDirection)
+		m.recordHistory(ctx, PlanStep{Version: version, Direction: migrationDirection}, COMMAND_RECOVER, 0, err)
+		if err != nil {
+			return err
+		}
+
+		m.report(Event{Type: EVENT_COMPLETED, Version: version, Direction: migrationDirection, Message: fmt.Sprintf("version %s marked as %s", version, state)})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	return nil
+}
+
+// checkDirtyVersions returns an error if any of mvs is marked dirty, because a partially applied migration must be
+// repaired before migrating further
+func checkDirtyVersions(mvs []models.MigrationVersion) error {
+	var dirty []string
+	for _, mv := range mvs {
+		if mv.Dirty {
+			dirty = append(dirty, mv.Version)
+		}
+	}
+
+	if len(dirty) > 0 {
+		return fmt.Errorf("%w (%s). Repair the db manually and run recover", ErrDirtyVersions, strings.Join(dirty, ", "))
+	}
+
+	return nil
+}
+
 // CurrentVersion returns the current db migration version
 func (m Migrator) CurrentVersion() (string, error) {
-	version, err := m.DBRepository.CurrentVersion()
+	return m.CurrentVersionContext(context.Background())
+}
+
+// CurrentVersionContext is like CurrentVersion but uses ctx for all db operations
+func (m Migrator) CurrentVersionContext(ctx context.Context) (string, error) {
+	version, err := m.DBRepository.CurrentVersionContext(ctx)
 	if err != nil {
-		return "", fmt.Errorf("currentVersion - %s", err)
+		return "", fmt.Errorf("currentVersion - %w", err)
 	}
 
 	return version, nil
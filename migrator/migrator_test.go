@@ -1,6 +1,26 @@
 package migrator
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/dhanekom/dbmigrator/config"
+	"github.com/dhanekom/dbmigrator/dbrepo"
+	"github.com/dhanekom/dbmigrator/models"
+	"github.com/fatih/color"
+)
 
 func TestMigrator_Goto(t *testing.T) {
 	// type args struct {
@@ -22,3 +42,1047 @@ func TestMigrator_Goto(t *testing.T) {
 	// 	})
 	// }
 }
+
+func TestNewStatusReport(t *testing.T) {
+	mvs := []models.MigrationVersion{
+		{Version: "20230101_000000", Desc: "a", ExistsInDB: true, UpFileExists: true, DownFileExists: true, Duration: 1500 * time.Millisecond, DBUser: "postgres", OSUser: "deploy", Hostname: "ci"},
+		{Version: "20230102_000000", Desc: "b", UpFileExists: true, DownFileExists: true},
+		{Version: "20230103_000000", ExistsInDB: true},
+		{Version: "20230104_000000", Desc: "d", UpFileExists: true},
+		{Version: "20230105_000000", Desc: "e", ExistsInDB: true, UpFileExists: true, DownFileExists: true, Dirty: true},
+	}
+
+	report := NewStatusReport(mvs, "20230103_000000")
+
+	want := []string{STATUS_APPLIED, STATUS_GAP, STATUS_ORPHANED, STATUS_PENDING, STATUS_DIRTY}
+	for i, ms := range report.Migrations {
+		if ms.Status != want[i] {
+			t.Errorf("NewStatusReport() version %s status = %q, want %q", ms.Version, ms.Status, want[i])
+		}
+	}
+
+	if report.Migrations[0].AppliedAt == nil || report.Migrations[1].AppliedAt != nil {
+		t.Errorf("NewStatusReport() AppliedAt must only be set for applied versions")
+	}
+
+	if got := report.Migrations[0]; got.DurationMs != 1500 || appliedByText(got) != "deploy@ci (postgres)" {
+		t.Errorf("NewStatusReport() version %s duration = %d, applied by = %q", got.Version, got.DurationMs, appliedByText(got))
+	}
+
+	if !report.Migrations[3].MissingDownFile || report.Migrations[3].MissingUpFile {
+		t.Errorf("NewStatusReport() version %s must only be missing a down file", report.Migrations[3].Version)
+	}
+}
+
+func TestRender(t *testing.T) {
+	report := ValidationResult{Valid: true}
+	tests := []struct {
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{format: "", want: "no issues found\n"},
+		{format: "TABLE", want: "no issues found\n"},
+		{format: FORMAT_JSON, want: "{\n  \"valid\": true"},
+		{format: "xml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := report.Render(&buf, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidationResult.Render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !strings.HasPrefix(buf.String(), tt.want) {
+				t.Errorf("ValidationResult.Render() = %q, want prefix %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrator_resolvePlan(t *testing.T) {
+	db, err := dbrepo.NewDBRepo(dbrepo.DBDRIVER_POSTGRES, dbrepo.DBConnectionData{}, &config.AppConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMigrator(NewDirSource(""), db, &config.AppConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mvs := []models.MigrationVersion{
+		{Version: "20230101_000000", Desc: "a", ExistsInDB: true, UpFileExists: true, DownFileExists: true},
+		{Version: "20230102_000000", Desc: "b", ExistsInDB: true, UpFileExists: true, DownFileExists: true},
+		{Version: "20230103_000000", Desc: "c", UpFileExists: true, DownFileExists: true},
+		{Version: "20230104_000000", Desc: "d", UpFileExists: true, DownFileExists: true},
+	}
+
+	tests := []struct {
+		name      string
+		command   string
+		toVersion string
+		want      []string
+		wantErr   error
+	}{
+		{"up all", COMMAND_UP, "", []string{"20230103_000000", "20230104_000000"}, nil},
+		{"up 1", COMMAND_UP, "1", []string{"20230103_000000"}, nil},
+		{"down 1", COMMAND_DOWN, "1", []string{"20230102_000000"}, nil},
+		{"down all", COMMAND_DOWN, "", []string{"20230102_000000", "20230101_000000"}, nil},
+		{"goto", COMMAND_GOTO, "20230104_000000", []string{"20230103_000000", "20230104_000000"}, nil},
+		{"goto unknown version", COMMAND_GOTO, "20230105_000000", nil, ErrVersionNotFound},
+		{"force", COMMAND_FORCE, "20230103_000000", []string{"20230103_000000"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noOfMigrations, err := parseMigrationArgs(tt.command, tt.toVersion)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			plan, err := m.resolvePlan(mvs, "20230102_000000", tt.command, tt.toVersion, noOfMigrations)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Migrator.resolvePlan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			var got []string
+			for _, step := range plan.Steps {
+				got = append(got, step.Version)
+			}
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Migrator.resolvePlan() steps = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrator_GetConfirmation(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	tests := []struct {
+		name      string
+		confirmer Confirmer
+		wantErr   bool
+	}{
+		{"always yes", AlwaysYesConfirmer{}, false},
+		{"always no", AlwaysNoConfirmer{}, true},
+		{"matching answer", ConfirmerFunc(func(prompt string, trueValues []string) (bool, error) {
+			return matchesAny(" Y\n", trueValues), nil
+		}), false},
+		{"non-matching answer", ConfirmerFunc(func(prompt string, trueValues []string) (bool, error) {
+			return matchesAny("no", trueValues), nil
+		}), true},
+		{"non-terminal input", NewTerminalConfirmer(r, io.Discard), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Migrator{App: &config.AppConfig{}, Confirmer: tt.confirmer}
+			err := m.GetConfirmation("continue?", []string{"yes", "y"})
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrCancelled)) {
+				t.Errorf("Migrator.GetConfirmation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMigrator_RegisterGoMigration(t *testing.T) {
+	source := fstest.MapFS{
+		"20230101_000000_create_users.up.sql":   {Data: []byte("create table users (id int);")},
+		"20230101_000000_create_users.down.sql": {Data: []byte("drop table users;")},
+		"20230102_000000_backfill.down.sql":     {Data: []byte("update users set name = null;")},
+	}
+
+	m, err := NewMigrator(source, nil, &config.AppConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noop := func(tx *sql.Tx) error { return nil }
+	if err := m.RegisterGoMigration("20230102_000000", "backfill", noop, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RegisterGoMigration("20230103_000000", "reencode", noop, noop); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RegisterGoMigration("2023-01-04", "invalid", noop, noop); err == nil {
+		t.Errorf("Migrator.RegisterGoMigration() expected an error for an invalid version")
+	}
+
+	mvs, err := m.GetMigrationVersionInfoMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mvs) != 3 {
+		t.Fatalf("Migrator.GetMigrationVersionInfoMap() returned %d versions, want 3", len(mvs))
+	}
+
+	backfill := mvs["20230102_000000"]
+	if !backfill.HasMigration(DIRECTION_UP) || backfill.UpFileExists || !backfill.DownFileExists {
+		t.Errorf("Migrator.GetMigrationVersionInfoMap() Go up migration not merged with down migration file")
+	}
+
+	if err := m.RegisterGoMigration("20230101_000000", "create_users", noop, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetMigrationVersionInfoMap(); err == nil {
+		t.Errorf("Migrator.GetMigrationVersionInfoMap() expected an error when a file and Go migration have the same direction")
+	}
+}
+
+func TestMigrator_Validate(t *testing.T) {
+	source := fstest.MapFS{
+		"20230101_000000_create_users.up.sql":   {Data: []byte("create table users (id int);")},
+		"20230101_000000_create_users.down.sql": {Data: []byte("drop table users;")},
+		"20230101_1200_typo.up.sql":             {Data: []byte("select 1;")},
+		"20230102_000000_create_users.up.sql":   {Data: []byte(" \n")},
+		"20230103_000000_a.up.sql":              {Data: []byte("select 1;")},
+		"20230103_000000_b.down.sql":            {Data: []byte("select 1;")},
+		"20231301_000000_bad_month.up.sql":      {Data: []byte("select 1;")},
+		"20231301_000000_bad_month.down.sql":    {Data: []byte("select 1;")},
+		"99990101_000000_future.up.sql":         {Data: []byte("select 1;")},
+		"99990101_000000_future.down.sql":       {Data: []byte("select 1;")},
+		"readme.md":                             {Data: []byte("not a migration")},
+	}
+
+	m, err := NewMigrator(source, nil, &config.AppConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := m.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]int)
+	for _, issue := range result.Issues {
+		got[issue.Type]++
+	}
+
+	want := map[string]int{
+		ISSUE_INVALID_FILENAME:  1,
+		ISSUE_EMPTY_FILE:        1,
+		ISSUE_MISSING_DOWN_FILE: 1,
+		ISSUE_DUPLICATE_DESC:    1,
+		ISSUE_DUPLICATE_VERSION: 1,
+		ISSUE_INVALID_VERSION:   1,
+		ISSUE_FUTURE_VERSION:    1,
+	}
+
+	if result.Valid || !reflect.DeepEqual(got, want) {
+		t.Errorf("Migrator.Validate() issues = %v, want %v", got, want)
+	}
+}
+
+func TestMigrator_resolvePlanOutOfOrder(t *testing.T) {
+	db, err := dbrepo.NewDBRepo(dbrepo.DBDRIVER_POSTGRES, dbrepo.DBConnectionData{}, &config.AppConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mvs := []models.MigrationVersion{
+		{Version: "20230101_000000", Desc: "a", ExistsInDB: true, UpFileExists: true, DownFileExists: true},
+		{Version: "20230102_000000", Desc: "b", UpFileExists: true, DownFileExists: true},
+		{Version: "20230103_000000", Desc: "c", ExistsInDB: true, UpFileExists: true, DownFileExists: true},
+		{Version: "20230104_000000", Desc: "d", UpFileExists: true, DownFileExists: true},
+	}
+
+	m, err := NewMigrator(NewDirSource(""), db, &config.AppConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.resolvePlan(mvs, "20230103_000000", COMMAND_UP, "", 0); err == nil {
+		t.Fatalf("Migrator.resolvePlan() expected an error when out-of-order migrations are not allowed")
+	}
+
+	m.App.AllowOutOfOrder = true
+	plan, err := m.resolvePlan(mvs, "20230103_000000", COMMAND_UP, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Steps) != 2 || plan.Steps[0].Version != "20230102_000000" || !plan.Steps[0].OutOfOrder || plan.Steps[1].Version != "20230104_000000" {
+		t.Errorf("Migrator.resolvePlan() steps = %+v, want out-of-order 20230102_000000 followed by 20230104_000000", plan.Steps)
+	}
+}
+
+func Test_parseMigrationScript(t *testing.T) {
+	tests := []struct {
+		name              string
+		data              string
+		wantNoTransaction bool
+		wantErr           bool
+	}{
+		{"no directives", "create table users (id int);", false, false},
+		{"no-transaction", "-- add index\n--  dbmigrator:no-transaction\n\ncreate index concurrently users_idx on users (id);", true, false},
+		{"directive after first statement", "select 1;\n-- dbmigrator:no-transaction\n", false, false},
+		{"unknown directive", "-- dbmigrator:no-transactions\nselect 1;", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, err := parseMigrationScript("20230101_000000_a.up.sql", []byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMigrationScript() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if script.NoTransaction != tt.wantNoTransaction {
+				t.Errorf("parseMigrationScript() NoTransaction = %v, want %v", script.NoTransaction, tt.wantNoTransaction)
+			}
+		})
+	}
+}
+
+func Test_checkDirtyVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		mvs     []models.MigrationVersion
+		wantErr bool
+	}{
+		{name: "clean", mvs: []models.MigrationVersion{{Version: "20230101_000000", ExistsInDB: true}}},
+		{name: "dirty", mvs: []models.MigrationVersion{{Version: "20230101_000000", ExistsInDB: true}, {Version: "20230102_000000", ExistsInDB: true, Dirty: true}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDirtyVersions(tt.mvs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkDirtyVersions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil && !strings.Contains(err.Error(), "20230102_000000") {
+				t.Errorf("checkDirtyVersions() error = %v, must name the dirty version", err)
+			}
+		})
+	}
+}
+
+func TestMigrator_resolvePlanGaps(t *testing.T) {
+	m := Migrator{App: &config.AppConfig{}}
+	mvs := []models.MigrationVersion{
+		{Version: "20230101_000000", Desc: "a", UpFileExists: true, DownFileExists: true},
+		{Version: "20230102_000000", Desc: "b", ExistsInDB: true, UpFileExists: true, DownFileExists: true},
+		{Version: "20230103_000000", Desc: "c", UpFileExists: true, DownFileExists: true},
+	}
+
+	_, err := m.resolvePlan(mvs, "20230102_000000", COMMAND_UP, "", 0)
+	if !errors.Is(err, ErrGapsDetected) {
+		t.Errorf("Migrator.resolvePlan() error = %v, wantErr %v", err, ErrGapsDetected)
+	}
+
+	_, err = m.resolvePlan(mvs[1:], "20230102_000000", COMMAND_DOWN, "20230103_000000", 0)
+	if !errors.Is(err, ErrWrongDirection) {
+		t.Errorf("Migrator.resolvePlan() error = %v, wantErr %v", err, ErrWrongDirection)
+	}
+}
+
+func TestCancelledError_Is(t *testing.T) {
+	err := fmt.Errorf("migrate - %w", &CancelledError{Err: context.Canceled})
+	if !errors.Is(err, ErrCancelled) || !errors.Is(err, context.Canceled) {
+		t.Errorf("errors.Is(%v) must match ErrCancelled and context.Canceled", err)
+	}
+}
+
+func TestMigrator_renderMigrationScript(t *testing.T) {
+	t.Setenv(TEMPLATE_ENV_PREFIX+"owner", "env_owner")
+	t.Setenv(TEMPLATE_ENV_PREFIX+"schema", "env_schema")
+
+	m := Migrator{App: &config.AppConfig{TemplateVars: map[string]string{"schema": "app"}}}
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{name: "not a template", data: "create table {{.schema}}.a (id int);", want: "create table {{.schema}}.a (id int);"},
+		{name: "config and environment variables", data: "-- dbmigrator:template\ncreate table {{.schema}}.a (id int);\nalter table {{.schema}}.a owner to {{.owner}};", want: "-- dbmigrator:template\ncreate table app.a (id int);\nalter table app.a owner to env_owner;"},
+		{name: "missing variable", data: "-- dbmigrator:template\ncreate table a (id int) tablespace {{.tablespace}};", wantErr: true},
+		{name: "invalid template", data: "-- dbmigrator:template\ncreate table {{.schema.a (id int);", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, err := parseMigrationScript("20230101_000000_a.up.sql", []byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := m.renderMigrationScript(script)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Migrator.renderMigrationScript() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err == nil && got.SQL != tt.want {
+				t.Errorf("Migrator.renderMigrationScript() = %q, want %q", got.SQL, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrator_SQLite(t *testing.T) {
+	source := fstest.MapFS{
+		"20230101_000000_users.up.sql":     {Data: []byte("create table users (id integer primary key, name text);\ncreate table audit (name text);")},
+		"20230101_000000_users.down.sql":   {Data: []byte("drop table audit;\ndrop table users;")},
+		"20230102_000000_trigger.up.sql":   {Data: []byte("create trigger users_audit after insert on users begin\n  insert into audit values (new.name);\nend;\ninsert into users (name) values ('a');")},
+		"20230102_000000_trigger.down.sql": {Data: []byte("drop trigger users_audit;")},
+		"20230103_000000_broken.up.sql":    {Data: []byte("create table c (id integer);\ninsert into missing values (1);")},
+		"20230103_000000_broken.down.sql":  {Data: []byte("drop table c;")},
+	}
+
+	app := &config.AppConfig{SilentMode: true}
+	db, err := dbrepo.NewDBRepo(dbrepo.DBDRIVER_SQLITE, dbrepo.DBConnectionData{DBName: dbrepo.SQLITE_MEMORY}, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := NewMigrator(source, db, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Up("2"); err != nil {
+		t.Fatalf("Migrator.Up() error = %v", err)
+	}
+
+	// The failing migration must be rolled back completely, including the table it created
+	if err := m.Up(""); err == nil {
+		t.Fatalf("Migrator.Up() must fail for a broken migration")
+	}
+
+	status, err := m.Status()
+	if err != nil {
+		t.Fatalf("Migrator.Status() error = %v", err)
+	}
+
+	var got []string
+	for _, ms := range status.Migrations {
+		got = append(got, ms.Status)
+	}
+	if want := []string{STATUS_APPLIED, STATUS_APPLIED, STATUS_PENDING}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Migrator.Status() = %v, want %v", got, want)
+	}
+
+	// Running the broken migration again fails on the same statement, so the table it creates was rolled back
+	var migErr *dbrepo.MigrationError
+	if err := m.Up(""); !errors.As(err, &migErr) || migErr.Statement != 2 {
+		t.Errorf("Migrator.Up() error = %v, want a failure of statement 2", err)
+	}
+
+	if err := m.Down("2"); err != nil {
+		t.Fatalf("Migrator.Down() error = %v", err)
+	}
+
+	report, err := m.History(models.HistoryFilter{Version: "20230102_000000", From: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("Migrator.History() error = %v", err)
+	}
+
+	if len(report.Entries) != 2 || report.Entries[0].Direction != DIRECTION_UP || report.Entries[1].Direction != DIRECTION_DOWN {
+		t.Errorf("Migrator.History() = %+v, want the up and down migration of 20230102_000000", report.Entries)
+	}
+}
+
+// newSQLiteMigrator returns a silent Migrator for source that migrates a private in-memory SQLite db
+func newSQLiteMigrator(t *testing.T, source fstest.MapFS, app *config.AppConfig) *Migrator {
+	t.Helper()
+
+	db, err := dbrepo.NewDBRepo(dbrepo.DBDRIVER_SQLITE, dbrepo.DBConnectionData{DBName: dbrepo.SQLITE_MEMORY}, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := NewMigrator(source, db, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Reporter = SilentReporter{}
+	return m
+}
+
+// migrationStatuses returns the status of every version of the Migrator by version
+func migrationStatuses(t *testing.T, m *Migrator) map[string]string {
+	t.Helper()
+
+	report, err := m.Status()
+	if err != nil {
+		t.Fatalf("Migrator.Status() error = %v", err)
+	}
+
+	statuses := make(map[string]string)
+	for _, ms := range report.Migrations {
+		statuses[ms.Version] = ms.Status
+	}
+
+	return statuses
+}
+
+// addTableMigration adds up and down migration files to source that create and drop a table named after desc
+func addTableMigration(source fstest.MapFS, version, desc string, withDown bool) {
+	source[version+"_"+desc+".up.sql"] = &fstest.MapFile{Data: []byte("create table " + desc + " (id integer);")}
+	if withDown {
+		source[version+"_"+desc+".down.sql"] = &fstest.MapFile{Data: []byte("drop table " + desc + ";")}
+	}
+}
+
+func TestMigrator_Fix(t *testing.T) {
+	tests := []struct {
+		name         string
+		allowFix     bool
+		gapHasDown   bool
+		modified     bool
+		wantErr      string
+		wantStatuses map[string]string
+		wantHistory  []string
+	}{
+		{
+			name:         "gap fixed",
+			allowFix:     true,
+			gapHasDown:   true,
+			wantStatuses: map[string]string{"20230101_000000": STATUS_APPLIED, "20230102_000000": STATUS_APPLIED, "20230103_000000": STATUS_APPLIED},
+			wantHistory:  []string{"20230103_000000 down", "20230102_000000 up", "20230103_000000 up"},
+		},
+		{
+			name:         "missing down file",
+			allowFix:     true,
+			wantErr:      "down migration file for version 20230103_000000 not found",
+			wantStatuses: map[string]string{"20230101_000000": STATUS_APPLIED, "20230102_000000": STATUS_GAP, "20230103_000000": STATUS_APPLIED},
+		},
+		{
+			name:         "modified migration refused",
+			allowFix:     true,
+			gapHasDown:   true,
+			modified:     true,
+			wantErr:      "have been modified since they were applied (20230103_000000_c.up.sql)",
+			wantStatuses: map[string]string{"20230101_000000": STATUS_APPLIED, "20230102_000000": STATUS_GAP, "20230103_000000": STATUS_APPLIED},
+		},
+		{
+			name:         "fix not allowed",
+			gapHasDown:   true,
+			wantErr:      "not allowed",
+			wantStatuses: map[string]string{"20230101_000000": STATUS_APPLIED, "20230102_000000": STATUS_GAP, "20230103_000000": STATUS_APPLIED},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := fstest.MapFS{}
+			addTableMigration(source, "20230101_000000", "a", true)
+			addTableMigration(source, "20230103_000000", "c", tt.gapHasDown)
+
+			app := &config.AppConfig{SilentMode: true, AllowFix: tt.allowFix, RefuseModifiedMigrations: tt.modified}
+			m := newSQLiteMigrator(t, source, app)
+			if err := m.Up(""); err != nil {
+				t.Fatalf("Migrator.Up() error = %v", err)
+			}
+
+			if tt.modified {
+				source["20230103_000000_c.up.sql"] = &fstest.MapFile{Data: []byte("create table c (id integer, name text);")}
+			}
+
+			// A migration that is merged after newer migrations were applied leaves a gap
+			addTableMigration(source, "20230102_000000", "b", true)
+
+			err := m.Fix()
+			if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Migrator.Fix() error = %v, wantErr %q", err, tt.wantErr)
+			}
+
+			if got := migrationStatuses(t, m); !reflect.DeepEqual(got, tt.wantStatuses) {
+				t.Errorf("Migrator.Fix() statuses = %v, want %v", got, tt.wantStatuses)
+			}
+
+			report, err := m.History(models.HistoryFilter{})
+			if err != nil {
+				t.Fatalf("Migrator.History() error = %v", err)
+			}
+
+			var got []string
+			for _, entry := range report.Entries {
+				if entry.Command == COMMAND_FIX {
+					got = append(got, entry.Version+" "+entry.Direction)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.wantHistory) {
+				t.Errorf("Migrator.Fix() ran %v, want %v", got, tt.wantHistory)
+			}
+		})
+	}
+}
+
+func TestMigrator_DownOrphaned(t *testing.T) {
+	source := fstest.MapFS{}
+	addTableMigration(source, "20230101_000000", "a", true)
+	addTableMigration(source, "20230102_000000", "b", true)
+	addTableMigration(source, "20230103_000000", "c", true)
+
+	app := &config.AppConfig{SilentMode: true}
+	m := newSQLiteMigrator(t, source, app)
+	if err := m.Up(""); err != nil {
+		t.Fatalf("Migrator.Up() error = %v", err)
+	}
+
+	// 20230102_000000 remains in the db after its files were deleted
+	delete(source, "20230102_000000_b.up.sql")
+	delete(source, "20230102_000000_b.down.sql")
+
+	for _, command := range []string{COMMAND_DOWN, COMMAND_GOTO} {
+		var err error
+		if command == COMMAND_DOWN {
+			err = m.Down("2")
+		} else {
+			err = m.Goto("20230101_000000")
+		}
+
+		if err == nil || !strings.Contains(err.Error(), "down migration file for version 20230102_000000 not found") {
+			t.Errorf("Migrator.Migrate(%s) error = %v, want the missing down file of the orphaned version", command, err)
+		}
+	}
+
+	want := map[string]string{"20230101_000000": STATUS_APPLIED, "20230102_000000": STATUS_ORPHANED, "20230103_000000": STATUS_APPLIED}
+	if got := migrationStatuses(t, m); !reflect.DeepEqual(got, want) {
+		t.Errorf("Migrator.Migrate() statuses = %v, want %v, no migrations may run", got, want)
+	}
+}
+
+// migrationTableExists reports whether the migration table of the Migrator was created
+func migrationTableExists(t *testing.T, m *Migrator) bool {
+	t.Helper()
+
+	if err := m.DBRepository.ConnectToDB(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.DBRepository.CloseDB()
+
+	exists, err := m.DBRepository.MigrationTableExists()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return exists
+}
+
+func TestMigrator_StatusNotInitialised(t *testing.T) {
+	source := fstest.MapFS{}
+	addTableMigration(source, "20230101_000000", "a", true)
+
+	m := newSQLiteMigrator(t, source, &config.AppConfig{SilentMode: true})
+	report, err := m.Status()
+	if err != nil {
+		t.Fatalf("Migrator.Status() error = %v", err)
+	}
+
+	if report.Initialised || len(report.Migrations) != 1 || report.Migrations[0].Status != STATUS_PENDING {
+		t.Errorf("Migrator.Status() = %+v, want a report that is not initialised with a pending migration", report)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteTable(&buf); err != nil || !strings.Contains(buf.String(), notInitialisedMessage) {
+		t.Errorf("StatusReport.WriteTable() = %q, %v, want %q", buf.String(), err, notInitialisedMessage)
+	}
+
+	if migrationTableExists(t, m) {
+		t.Errorf("Migrator.Status() created the migration table")
+	}
+}
+
+func TestMigrator_VerifyNotInitialised(t *testing.T) {
+	source := fstest.MapFS{}
+	addTableMigration(source, "20230101_000000", "a", true)
+
+	m := newSQLiteMigrator(t, source, &config.AppConfig{SilentMode: true})
+	mismatches, err := m.Verify()
+	if err != nil || len(mismatches) != 0 {
+		t.Errorf("Migrator.Verify() = %v, %v, want no mismatches", mismatches, err)
+	}
+
+	if migrationTableExists(t, m) {
+		t.Errorf("Migrator.Verify() created the migration table")
+	}
+}
+
+func TestMigrator_HistoryNotInitialised(t *testing.T) {
+	source := fstest.MapFS{}
+	addTableMigration(source, "20230101_000000", "a", true)
+
+	m := newSQLiteMigrator(t, source, &config.AppConfig{SilentMode: true})
+	report, err := m.History(models.HistoryFilter{})
+	if err != nil || len(report.Entries) != 0 {
+		t.Errorf("Migrator.History() = %+v, %v, want no entries", report, err)
+	}
+
+	if migrationTableExists(t, m) {
+		t.Errorf("Migrator.History() created the migration table")
+	}
+}
+
+func TestMigrator_NoMigrations(t *testing.T) {
+	m := newSQLiteMigrator(t, fstest.MapFS{}, &config.AppConfig{SilentMode: true})
+
+	if _, err := m.Plan(COMMAND_UP, ""); !errors.Is(err, ErrNoMigrations) {
+		t.Errorf("Migrator.Plan() error = %v, want %v", err, ErrNoMigrations)
+	}
+
+	if err := m.Up(""); !errors.Is(err, ErrNoMigrations) {
+		t.Errorf("Migrator.Up() error = %v, want %v", err, ErrNoMigrations)
+	}
+}
+
+func TestMigrator_PlanDirty(t *testing.T) {
+	source := fstest.MapFS{
+		"20230102_000000_b.up.sql": {Data: []byte("-- dbmigrator:no-transaction\ncreate table b (id integer);\ninsert into missing values (1);")},
+	}
+	addTableMigration(source, "20230101_000000", "a", true)
+
+	m := newSQLiteMigrator(t, source, &config.AppConfig{SilentMode: true})
+	if err := m.Up(""); err == nil {
+		t.Fatal("Migrator.Up() error = nil, want the no-transaction migration to fail")
+	}
+
+	if _, err := m.Plan(COMMAND_UP, ""); !errors.Is(err, ErrDirtyVersions) {
+		t.Errorf("Migrator.Plan() error = %v, want %v", err, ErrDirtyVersions)
+	}
+}
+
+func TestMigrator_ApplyPlan(t *testing.T) {
+	tests := []struct {
+		name string
+		// change changes the db or the migrations after the plan was saved and returns the Migrator that applies it
+		change       func(t *testing.T, m *Migrator, source fstest.MapFS, app *config.AppConfig) *Migrator
+		refuseMod    bool
+		wantErr      string
+		wantStatuses map[string]string
+	}{
+		{
+			name:         "unchanged",
+			change:       func(t *testing.T, m *Migrator, source fstest.MapFS, app *config.AppConfig) *Migrator { return m },
+			wantStatuses: map[string]string{"20230101_000000": STATUS_APPLIED, "20230102_000000": STATUS_APPLIED, "20230103_000000": STATUS_APPLIED},
+		},
+		{
+			name: "changed version",
+			change: func(t *testing.T, m *Migrator, source fstest.MapFS, app *config.AppConfig) *Migrator {
+				if err := m.Up("1"); err != nil {
+					t.Fatal(err)
+				}
+				return m
+			},
+			wantErr:      "does not match the version the plan was created for",
+			wantStatuses: map[string]string{"20230101_000000": STATUS_APPLIED, "20230102_000000": STATUS_APPLIED, "20230103_000000": STATUS_PENDING},
+		},
+		{
+			name: "changed checksum",
+			change: func(t *testing.T, m *Migrator, source fstest.MapFS, app *config.AppConfig) *Migrator {
+				source["20230103_000000_c.up.sql"] = &fstest.MapFile{Data: []byte("create table c2 (id integer);")}
+				return m
+			},
+			wantErr:      "migration file 20230103_000000_c.up.sql changed since the plan was created",
+			wantStatuses: map[string]string{"20230101_000000": STATUS_APPLIED, "20230102_000000": STATUS_PENDING, "20230103_000000": STATUS_PENDING},
+		},
+		{
+			name: "changed rendered template",
+			change: func(t *testing.T, m *Migrator, source fstest.MapFS, app *config.AppConfig) *Migrator {
+				app.TemplateVars["suffix"] = "prod"
+				return m
+			},
+			wantErr:      "the rendered SQL of migration file 20230102_000000_b.up.sql changed",
+			wantStatuses: map[string]string{"20230101_000000": STATUS_APPLIED, "20230102_000000": STATUS_PENDING, "20230103_000000": STATUS_PENDING},
+		},
+		{
+			name: "mismatched identity",
+			change: func(t *testing.T, m *Migrator, source fstest.MapFS, app *config.AppConfig) *Migrator {
+				db, err := dbrepo.NewDBRepo(dbrepo.DBDRIVER_SQLITE, dbrepo.DBConnectionData{DBName: dbrepo.SQLITE_MEMORY, MigrationTable: "other_migration"}, app)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { db.Close() })
+
+				other, err := NewMigrator(source, db, app)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return other
+			},
+			wantErr:      "/schema_migration and cannot be applied to sqlite://@:/:memory:/other_migration",
+			wantStatuses: map[string]string{"20230101_000000": STATUS_APPLIED, "20230102_000000": STATUS_PENDING, "20230103_000000": STATUS_PENDING},
+		},
+		{
+			name: "other in-memory db",
+			change: func(t *testing.T, m *Migrator, source fstest.MapFS, app *config.AppConfig) *Migrator {
+				other := newSQLiteMigrator(t, source, app)
+				if err := other.Up("1"); err != nil {
+					t.Fatal(err)
+				}
+				return other
+			},
+			wantErr:      "cannot be applied to sqlite://@:/:memory:",
+			wantStatuses: map[string]string{"20230101_000000": STATUS_APPLIED, "20230102_000000": STATUS_PENDING, "20230103_000000": STATUS_PENDING},
+		},
+		{
+			name: "modified applied migration",
+			change: func(t *testing.T, m *Migrator, source fstest.MapFS, app *config.AppConfig) *Migrator {
+				source["20230101_000000_a.up.sql"] = &fstest.MapFile{Data: []byte("create table a (id integer, name text);")}
+				return m
+			},
+			refuseMod:    true,
+			wantErr:      "1 applied migration file(s) have been modified since they were applied (20230101_000000_a.up.sql)",
+			wantStatuses: map[string]string{"20230101_000000": STATUS_APPLIED, "20230102_000000": STATUS_PENDING, "20230103_000000": STATUS_PENDING},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := fstest.MapFS{
+				"20230102_000000_b.up.sql":   {Data: []byte("-- dbmigrator:template\ncreate table b_{{.suffix}} (id integer);")},
+				"20230102_000000_b.down.sql": {Data: []byte("-- dbmigrator:template\ndrop table b_{{.suffix}};")},
+			}
+			addTableMigration(source, "20230101_000000", "a", true)
+			addTableMigration(source, "20230103_000000", "c", true)
+
+			app := &config.AppConfig{SilentMode: true, RefuseModifiedMigrations: tt.refuseMod, TemplateVars: map[string]string{"suffix": "test"}}
+			m := newSQLiteMigrator(t, source, app)
+			if err := m.Up("1"); err != nil {
+				t.Fatalf("Migrator.Up() error = %v", err)
+			}
+
+			filename := filepath.Join(t.TempDir(), "plan.json")
+			plan, err := m.SavePlan(COMMAND_UP, "", filename)
+			if err != nil {
+				t.Fatalf("Migrator.SavePlan() error = %v", err)
+			}
+
+			loaded, err := LoadPlan(filename)
+			if err != nil || !reflect.DeepEqual(loaded.Steps, plan.Steps) || loaded.Target != plan.Target {
+				t.Fatalf("LoadPlan() = %+v, %v, want %+v", loaded, err, plan)
+			}
+
+			err = tt.change(t, m, source, app).ApplyPlan(filename)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Migrator.ApplyPlan() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Migrator.ApplyPlan() error = %v, want %q", err, tt.wantErr)
+			}
+
+			if got := migrationStatuses(t, m); !reflect.DeepEqual(got, tt.wantStatuses) {
+				t.Errorf("Migrator.ApplyPlan() statuses = %v, want %v", got, tt.wantStatuses)
+			}
+		})
+	}
+}
+
+// cancelReporter cancels the context of a migration run once the first migration succeeded
+type cancelReporter struct {
+	cancel context.CancelFunc
+}
+
+func (r cancelReporter) Report(e Event) {
+	if e.Type == EVENT_MIGRATION_SUCCEEDED {
+		r.cancel()
+	}
+}
+
+func TestMigrator_MigrateCancelled(t *testing.T) {
+	source := fstest.MapFS{}
+	addTableMigration(source, "20230101_000000", "a", true)
+	addTableMigration(source, "20230102_000000", "b", true)
+	addTableMigration(source, "20230103_000000", "c", true)
+
+	m := newSQLiteMigrator(t, source, &config.AppConfig{SilentMode: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.Reporter = cancelReporter{cancel: cancel}
+
+	err := m.MigrateContext(ctx, COMMAND_UP, "")
+	if !errors.Is(err, ErrCancelled) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Migrator.MigrateContext() error = %v, want %v", err, ErrCancelled)
+	}
+
+	var cancelledErr *CancelledError
+	if !errors.As(err, &cancelledErr) {
+		t.Fatalf("Migrator.MigrateContext() error = %v, want a *CancelledError", err)
+	}
+
+	if len(cancelledErr.Completed) != 1 || cancelledErr.Completed[0].Version != "20230101_000000" {
+		t.Errorf("CancelledError.Completed = %+v, want only version 20230101_000000", cancelledErr.Completed)
+	}
+
+	m.Reporter = SilentReporter{}
+	want := map[string]string{"20230101_000000": STATUS_APPLIED, "20230102_000000": STATUS_PENDING, "20230103_000000": STATUS_PENDING}
+	if got := migrationStatuses(t, m); !reflect.DeepEqual(got, want) {
+		t.Errorf("Migrator.MigrateContext() statuses = %v, want %v", got, want)
+	}
+}
+
+func TestMigrator_Verify(t *testing.T) {
+	for _, refuseMod := range []bool{false, true} {
+		t.Run(fmt.Sprintf("refuse modified migrations %v", refuseMod), func(t *testing.T) {
+			source := fstest.MapFS{}
+			addTableMigration(source, "20230101_000000", "a", true)
+			addTableMigration(source, "20230102_000000", "b", true)
+			addTableMigration(source, "20230103_000000", "c", true)
+
+			app := &config.AppConfig{SilentMode: true, RefuseModifiedMigrations: refuseMod}
+			m := newSQLiteMigrator(t, source, app)
+			if err := m.Up("2"); err != nil {
+				t.Fatalf("Migrator.Up() error = %v", err)
+			}
+
+			// Only the checksum of up migration files is stored, so the modified down file is not reported
+			source["20230101_000000_a.up.sql"] = &fstest.MapFile{Data: []byte("create table a (id integer, name text);")}
+			source["20230102_000000_b.down.sql"] = &fstest.MapFile{Data: []byte("drop table if exists b;")}
+
+			mismatches, err := m.Verify()
+			if err != nil {
+				t.Fatalf("Migrator.Verify() error = %v", err)
+			}
+
+			if len(mismatches) != 1 || mismatches[0].Version != "20230101_000000" || mismatches[0].Filename != "20230101_000000_a.up.sql" || mismatches[0].StoredChecksum == mismatches[0].FileChecksum {
+				t.Errorf("Migrator.Verify() = %+v, want a mismatch of 20230101_000000_a.up.sql", mismatches)
+			}
+
+			err = m.Up("")
+			if refuseMod && (err == nil || !strings.Contains(err.Error(), "have been modified since they were applied (20230101_000000_a.up.sql)")) {
+				t.Errorf("Migrator.Up() error = %v, want the modified migration file to be refused", err)
+			}
+			if !refuseMod && err != nil {
+				t.Errorf("Migrator.Up() error = %v", err)
+			}
+
+			want := map[string]string{"20230101_000000": STATUS_APPLIED, "20230102_000000": STATUS_APPLIED, "20230103_000000": STATUS_APPLIED}
+			if refuseMod {
+				want["20230103_000000"] = STATUS_PENDING
+			}
+			if got := migrationStatuses(t, m); !reflect.DeepEqual(got, want) {
+				t.Errorf("Migrator.Up() statuses = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// reporterEvents are the events of a migration run that are reported by the reporter tests
+var reporterEvents = []Event{
+	{Type: EVENT_NOTICE, Message: "migration table not initialised"},
+	{Type: EVENT_FILE_CREATED, Filename: "migrations/20230101_000000_a.up.sql"},
+	{Type: EVENT_MIGRATION_STARTED, Version: "20230101_000000", Direction: DIRECTION_UP, Filename: "20230101_000000_a.up.sql"},
+	{Type: EVENT_MIGRATION_SUCCEEDED, Version: "20230101_000000", Direction: DIRECTION_UP, Filename: "20230101_000000_a.up.sql", Duration: 1500 * time.Millisecond},
+	{Type: EVENT_MIGRATION_STARTED, Version: "20230102_000000", Direction: DIRECTION_UP, Filename: "20230102_000000_b.up.sql"},
+	{Type: EVENT_MIGRATION_FAILED, Version: "20230102_000000", Direction: DIRECTION_UP, Filename: "20230102_000000_b.up.sql", Err: errors.New("syntax error")},
+	{Type: EVENT_FORCE_APPLIED, Version: "20230101_000000", Direction: DIRECTION_DOWN},
+	{Type: EVENT_COMPLETED, Message: "db migrated"},
+}
+
+func TestReporters(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+
+	tests := []struct {
+		name     string
+		reporter func(w io.Writer) Reporter
+		want     string
+	}{
+		{
+			name:     "color",
+			reporter: func(w io.Writer) Reporter { return NewColorReporter(w) },
+			want: "migration table not initialised\n" +
+				"creating migrations/20230101_000000_a.up.sql\n" +
+				"running up migration 20230101_000000_a.up.sql - success\n" +
+				"running up migration 20230102_000000_b.up.sql - failed\n" +
+				"forcing current version to 20230101_000000 - success\n" +
+				"db migrated\n",
+		},
+		{
+			name:     "plain",
+			reporter: func(w io.Writer) Reporter { return NewPlainReporter(w) },
+			want: "migration table not initialised\n" +
+				"creating migrations/20230101_000000_a.up.sql\n" +
+				"running up migration 20230101_000000_a.up.sql\n" +
+				"up migration 20230101_000000_a.up.sql succeeded in 1.5s\n" +
+				"running up migration 20230102_000000_b.up.sql\n" +
+				"up migration 20230102_000000_b.up.sql failed - syntax error\n" +
+				"forced current version to 20230101_000000\n" +
+				"db migrated\n",
+		},
+		{
+			name: "slog",
+			reporter: func(w io.Writer) Reporter {
+				return NewSlogReporter(slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+					ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+						if a.Key == slog.TimeKey {
+							return slog.Attr{}
+						}
+						return a
+					},
+				})))
+			},
+			want: `level=INFO msg="migration table not initialised" event=notice` + "\n" +
+				`level=INFO msg="migration file created" event=file_created file=migrations/20230101_000000_a.up.sql` + "\n" +
+				`level=INFO msg="migration started" event=migration_started version=20230101_000000 direction=up file=20230101_000000_a.up.sql` + "\n" +
+				`level=INFO msg="migration succeeded" event=migration_succeeded version=20230101_000000 direction=up file=20230101_000000_a.up.sql duration=1.5s` + "\n" +
+				`level=INFO msg="migration started" event=migration_started version=20230102_000000 direction=up file=20230102_000000_b.up.sql` + "\n" +
+				`level=ERROR msg="migration failed" event=migration_failed version=20230102_000000 direction=up file=20230102_000000_b.up.sql error="syntax error"` + "\n" +
+				`level=WARN msg="current version forced" event=force_applied version=20230101_000000 direction=down` + "\n" +
+				`level=INFO msg="db migrated" event=completed` + "\n",
+		},
+		{
+			name:     "silent",
+			reporter: func(w io.Writer) Reporter { return SilentReporter{} },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			r := tt.reporter(&buf)
+			for _, e := range reporterEvents {
+				r.Report(e)
+			}
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("Reporter.Report() wrote\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrator_Create(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "migrations")
+	app := &config.AppConfig{SilentMode: true}
+	m, err := NewMigrator(NewDirSource(dir), nil, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	m.Reporter = NewPlainReporter(&buf)
+	if err := m.Create("add users"); err != nil {
+		t.Fatalf("Migrator.Create() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Migrator.Create() reported %q, want 2 files", buf.String())
+	}
+
+	for i, direction := range []string{DIRECTION_UP, DIRECTION_DOWN} {
+		path := strings.TrimPrefix(lines[i], "creating ")
+		if filepath.Dir(path) != dir || !strings.HasSuffix(path, "_add_users."+direction+".sql") {
+			t.Errorf("Migrator.Create() reported %q, want the path of the %s file in %s", lines[i], direction, dir)
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Migrator.Create() did not create %s - %v", path, err)
+		}
+	}
+}
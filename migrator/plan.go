@@ -0,0 +1,339 @@
+package migrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dhanekom/dbmigrator/models"
+)
+
+// PlanStep describes a single migration that will be run when a MigrationPlan is executed. Filename is empty when
+// only the migration version is recorded (force command). SQL holds the rendered SQL of migration templates
+type PlanStep struct {
+	Version     string `json:"version"`
+	Desc        string `json:"description,omitempty"`
+	Direction   string `json:"direction"`
+	Filename    string `json:"filename,omitempty"`
+	GoMigration bool   `json:"go_migration,omitempty"`
+	OutOfOrder  bool   `json:"out_of_order,omitempty"`
+	NoTx        bool   `json:"no_transaction,omitempty"`
+	Checksum    string `json:"checksum,omitempty"`
+	Statement   string `json:"statement"`
+	SQL         string `json:"sql,omitempty"`
+}
+
+// MigrationPlan describes the migrations that will be run to migrate a db from CurrentVersion to ToVersion
+type MigrationPlan struct {
+	Target         string     `json:"target"`
+	CreatedOn      time.Time  `json:"created_on"`
+	Command        string     `json:"command"`
+	CurrentVersion string     `json:"current_version"`
+	ToVersion      string     `json:"to_version"`
+	Direction      string     `json:"direction"`
+	Steps          []PlanStep `json:"steps"`
+}
+
+// Plan determines which migrations will be run by the specified command without making any changes to the db. The
+// migration table is not created if it does not exist yet. Like Migrate, Plan refuses dirty and modified migrations and
+// returns ErrNoMigrations if the migration source is empty
+func (m *Migrator) Plan(command, toVersion string) (*MigrationPlan, error) {
+	return m.PlanContext(context.Background(), command, toVersion)
+}
+
+// PlanContext is like Plan but uses ctx for all db operations
+func (m *Migrator) PlanContext(ctx context.Context, command, toVersion string) (*MigrationPlan, error) {
+	funcPrefix := "plan"
+
+	noOfMigrations, err := parseMigrationArgs(command, toVersion)
+	if err != nil {
+		return nil, fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	err = m.DBRepository.ConnectToDBContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	defer func() {
+		m.DBRepository.CloseDB()
+	}()
+
+	tableExists, err := m.DBRepository.MigrationTableExistsContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	var mvs []models.MigrationVersion
+	var currentVersion string
+	if tableExists {
+		mvs, err = m.GetMigrationVersionInfoContext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf(funcPrefix+" - %w", err)
+		}
+
+		currentVersion, err = m.DBRepository.CurrentVersionContext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf(funcPrefix+" - %w", err)
+		}
+	} else {
+		mvMap, err := m.GetMigrationVersionInfoMap()
+		if err != nil {
+			return nil, fmt.Errorf(funcPrefix+" - %w", err)
+		}
+
+		mvs = sortMigrationVersions(mvMap)
+	}
+
+	plan, err := m.resolveMigration(mvs, currentVersion, command, toVersion, noOfMigrations)
+	if err != nil {
+		return nil, fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	plan.Target = m.DBRepository.Identity()
+	plan.CreatedOn = time.Now()
+	for i, step := range plan.Steps {
+		if step.Filename == "" || step.GoMigration {
+			continue
+		}
+
+		script, data, err := m.readMigrationScript(step.Filename)
+		if err != nil {
+			return nil, fmt.Errorf(funcPrefix+" - %w", err)
+		}
+
+		plan.Steps[i].Checksum = checksum(data)
+		plan.Steps[i].NoTx = script.NoTransaction
+		if script.Template {
+			plan.Steps[i].SQL = script.SQL
+		}
+	}
+
+	return plan, nil
+}
+
+// SavePlan determines which migrations will be run by the specified command and writes the plan to filename as JSON.
+// The plan can later be run with ApplyPlan
+func (m *Migrator) SavePlan(command, toVersion, filename string) (*MigrationPlan, error) {
+	return m.SavePlanContext(context.Background(), command, toVersion, filename)
+}
+
+// SavePlanContext is like SavePlan but uses ctx for all db operations
+func (m *Migrator) SavePlanContext(ctx context.Context, command, toVersion, filename string) (*MigrationPlan, error) {
+	plan, err := m.PlanContext(ctx, command, toVersion)
+	if err != nil {
+		return nil, fmt.Errorf("savePlan - %w", err)
+	}
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("savePlan - %w", err)
+	}
+
+	err = os.WriteFile(filename, data, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("savePlan - %w", err)
+	}
+
+	return plan, nil
+}
+
+// LoadPlan reads a plan that was written by SavePlan
+func LoadPlan(filename string) (*MigrationPlan, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("loadPlan - %w", err)
+	}
+
+	var plan MigrationPlan
+	err = json.Unmarshal(data, &plan)
+	if err != nil {
+		return nil, fmt.Errorf("loadPlan - %w", err)
+	}
+
+	return &plan, nil
+}
+
+// ApplyPlan runs exactly the migrations of a plan that was written by SavePlan. The plan is refused if it was created
+// for a different db, if the current db version changed or if any migration file changed since the plan was created
+func (m *Migrator) ApplyPlan(filename string) error {
+	return m.ApplyPlanContext(context.Background(), filename)
+}
+
+// ApplyPlanContext is like ApplyPlan but uses ctx for all db operations
+func (m *Migrator) ApplyPlanContext(ctx context.Context, filename string) error {
+	funcPrefix := "applyPlan"
+
+	plan, err := LoadPlan(filename)
+	if err != nil {
+		return fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	if plan.Target != m.DBRepository.Identity() {
+		return fmt.Errorf(funcPrefix+" - plan was created for %s and cannot be applied to %s", plan.Target, m.DBRepository.Identity())
+	}
+
+	if plan.Command != COMMAND_UP && plan.Command != COMMAND_DOWN && plan.Command != COMMAND_GOTO && plan.Command != COMMAND_FORCE {
+		return fmt.Errorf(funcPrefix+" - %q is not a valid migration command", plan.Command)
+	}
+
+	err = m.withLock(ctx, func() error {
+		mvs, err := m.GetMigrationVersionInfoContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = checkDirtyVersions(mvs)
+		if err != nil {
+			return err
+		}
+
+		err = m.checkModifiedMigrations(mvs)
+		if err != nil {
+			return err
+		}
+
+		currentVersion, err := m.DBRepository.CurrentVersionContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		if currentVersion != plan.CurrentVersion {
+			return fmt.Errorf("the current db version (%s) does not match the version the plan was created for (%s)", currentVersion, plan.CurrentVersion)
+		}
+
+		if len(plan.Steps) == 0 {
+			m.report(Event{Type: EVENT_COMPLETED, Message: "plan does not contain any migrations"})
+			return nil
+		}
+
+		mvMap, err := m.GetMigrationVersionInfoMap()
+		if err != nil {
+			return err
+		}
+
+		for _, step := range plan.Steps {
+			if step.Direction != plan.Direction {
+				return fmt.Errorf("version %s has direction %q but the plan direction is %q", step.Version, step.Direction, plan.Direction)
+			}
+
+			mv, ok := mvMap[step.Version]
+			if !ok {
+				mv = &models.MigrationVersion{Version: step.Version}
+			}
+
+			if step.GoMigration {
+				if !ok || mv.Func(step.Direction) == nil || mv.GoName(step.Direction) != step.Filename {
+					return fmt.Errorf("Go migration %s is no longer registered", step.Filename)
+				}
+			} else if step.Filename != "" {
+				if !ok || mv.Filename(step.Direction) != step.Filename {
+					return fmt.Errorf("migration file %s no longer exists", step.Filename)
+				}
+
+				script, data, err := m.readMigrationScript(step.Filename)
+				if err != nil {
+					return err
+				}
+
+				if checksum(data) != step.Checksum {
+					return fmt.Errorf("migration file %s changed since the plan was created", step.Filename)
+				}
+
+				if script.Template && script.SQL != step.SQL {
+					return fmt.Errorf("the rendered SQL of migration file %s changed since the plan was created", step.Filename)
+				}
+			} else if plan.Command != COMMAND_FORCE {
+				return fmt.Errorf("version %s does not have a migration file", step.Version)
+			}
+		}
+
+		if plan.Command != COMMAND_FORCE && plan.Direction == DIRECTION_DOWN && !m.confirmationProvided {
+			err := m.GetConfirmation(revertPrompt(plan.Steps), []string{"yes"})
+			if err != nil {
+				return err
+			}
+		}
+
+		err = m.runMigrations(ctx, plan.Steps, plan.Command)
+		if err != nil {
+			return err
+		}
+
+		if plan.Command == COMMAND_FORCE {
+			m.report(Event{Type: EVENT_FORCE_APPLIED, Version: plan.ToVersion, Direction: plan.Direction})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	return nil
+}
+
+// OutOfOrderCount returns the number of steps that run migrations older than the current version
+func (p MigrationPlan) OutOfOrderCount() int {
+	count := 0
+	for _, step := range p.Steps {
+		if step.OutOfOrder {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Render writes the plan to w in the specified format (table or json)
+func (p MigrationPlan) Render(w io.Writer, format string) error {
+	return render(w, format, p.WriteTable, p)
+}
+
+// WriteTable writes the plan to w as a human readable table
+func (p MigrationPlan) WriteTable(w io.Writer) error {
+	fmt.Fprintf(w, "%s from version %q to version %q\n", p.Command, p.CurrentVersion, p.ToVersion)
+	if len(p.Steps) == 0 {
+		fmt.Fprintln(w, "no migrations will be run")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tVERSION\tDIRECTION\tFILE\tSTATEMENT")
+	for i, step := range p.Steps {
+		filename := step.Filename
+		if filename == "" {
+			filename = "-"
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", i+1, step.Version, step.Direction, filename, step.Statement)
+	}
+
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	for _, step := range p.Steps {
+		if step.SQL == "" {
+			continue
+		}
+
+		_, err = fmt.Fprintf(w, "\n-- %s (rendered)\n%s\n", step.Filename, strings.TrimRight(step.SQL, "\n"))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteJSON writes the plan to w as indented JSON
+func (p MigrationPlan) WriteJSON(w io.Writer) error {
+	return writeJSON(w, p)
+}
@@ -0,0 +1,144 @@
+package migrator
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+type EventType string
+
+const (
+	EVENT_NOTICE              EventType = "notice"
+	EVENT_COMPLETED           EventType = "completed"
+	EVENT_FILE_CREATED        EventType = "file_created"
+	EVENT_MIGRATION_STARTED   EventType = "migration_started"
+	EVENT_MIGRATION_SUCCEEDED EventType = "migration_succeeded"
+	EVENT_MIGRATION_FAILED    EventType = "migration_failed"
+	EVENT_FORCE_APPLIED       EventType = "force_applied"
+)
+
+// Event describes something that happened while a Migrator command was running. Only the fields that are relevant
+// to the event type are set
+type Event struct {
+	Type      EventType
+	Message   string
+	Version   string
+	Direction string
+	Filename  string
+	Duration  time.Duration
+	Err       error
+}
+
+// Reporter receives the events of a Migrator. Set Migrator.Reporter to control where and how progress is reported
+type Reporter interface {
+	Report(e Event)
+}
+
+// ColorReporter writes events to a terminal using the Fmt_success, Fmt_error and Fmt_highlight colors
+type ColorReporter struct {
+	w io.Writer
+}
+
+// NewColorReporter creates a *ColorReporter that writes to w
+func NewColorReporter(w io.Writer) *ColorReporter {
+	return &ColorReporter{w: w}
+}
+
+func (r *ColorReporter) Report(e Event) {
+	switch e.Type {
+	case EVENT_NOTICE:
+		Fmt_highlight.Fprintln(r.w, e.Message)
+	case EVENT_COMPLETED:
+		Fmt_success.Fprintln(r.w, e.Message)
+	case EVENT_FILE_CREATED:
+		fmt.Fprintf(r.w, "creating %s\n", e.Filename)
+	case EVENT_MIGRATION_STARTED:
+		fmt.Fprintf(r.w, "running %s migration %s", e.Direction, e.Filename)
+	case EVENT_MIGRATION_SUCCEEDED:
+		Fmt_success.Fprintln(r.w, " - success")
+	case EVENT_MIGRATION_FAILED:
+		Fmt_error.Fprintln(r.w, " - failed")
+	case EVENT_FORCE_APPLIED:
+		Fmt_highlight.Fprintf(r.w, "forcing current version to %s", e.Version)
+		Fmt_success.Fprintln(r.w, " - success")
+	}
+}
+
+// PlainReporter writes one line of plain text per event to w
+type PlainReporter struct {
+	w io.Writer
+}
+
+// NewPlainReporter creates a *PlainReporter that writes to w
+func NewPlainReporter(w io.Writer) *PlainReporter {
+	return &PlainReporter{w: w}
+}
+
+func (r *PlainReporter) Report(e Event) {
+	switch e.Type {
+	case EVENT_NOTICE, EVENT_COMPLETED:
+		fmt.Fprintln(r.w, e.Message)
+	case EVENT_FILE_CREATED:
+		fmt.Fprintf(r.w, "creating %s\n", e.Filename)
+	case EVENT_MIGRATION_STARTED:
+		fmt.Fprintf(r.w, "running %s migration %s\n", e.Direction, e.Filename)
+	case EVENT_MIGRATION_SUCCEEDED:
+		fmt.Fprintf(r.w, "%s migration %s succeeded in %s\n", e.Direction, e.Filename, e.Duration)
+	case EVENT_MIGRATION_FAILED:
+		fmt.Fprintf(r.w, "%s migration %s failed - %s\n", e.Direction, e.Filename, e.Err)
+	case EVENT_FORCE_APPLIED:
+		fmt.Fprintf(r.w, "forced current version to %s\n", e.Version)
+	}
+}
+
+// SlogReporter writes events as structured log records to a *slog.Logger
+type SlogReporter struct {
+	logger *slog.Logger
+}
+
+// NewSlogReporter creates a *SlogReporter that logs to logger. slog.Default() is used if logger is nil
+func NewSlogReporter(logger *slog.Logger) *SlogReporter {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &SlogReporter{logger: logger}
+}
+
+func (r *SlogReporter) Report(e Event) {
+	attrs := []any{slog.String("event", string(e.Type))}
+	if e.Version != "" {
+		attrs = append(attrs, slog.String("version", e.Version))
+	}
+	if e.Direction != "" {
+		attrs = append(attrs, slog.String("direction", e.Direction))
+	}
+	if e.Filename != "" {
+		attrs = append(attrs, slog.String("file", e.Filename))
+	}
+	if e.Duration != 0 {
+		attrs = append(attrs, slog.Duration("duration", e.Duration))
+	}
+
+	switch e.Type {
+	case EVENT_NOTICE, EVENT_COMPLETED:
+		r.logger.Info(e.Message, attrs...)
+	case EVENT_FILE_CREATED:
+		r.logger.Info("migration file created", attrs...)
+	case EVENT_MIGRATION_STARTED:
+		r.logger.Info("migration started", attrs...)
+	case EVENT_MIGRATION_SUCCEEDED:
+		r.logger.Info("migration succeeded", attrs...)
+	case EVENT_MIGRATION_FAILED:
+		r.logger.Error("migration failed", append(attrs, slog.Any("error", e.Err))...)
+	case EVENT_FORCE_APPLIED:
+		r.logger.Warn("current version forced", attrs...)
+	}
+}
+
+// SilentReporter discards all events
+type SilentReporter struct{}
+
+func (SilentReporter) Report(e Event) {}
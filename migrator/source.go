@@ -0,0 +1,53 @@
+package migrator
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// WritableFS is a migration source that new migration files can be written to. Only Migrators created with a
+// WritableFS source support the create command
+type WritableFS interface {
+	fs.FS
+	WriteFile(name string, data []byte) error
+}
+
+// DirSource is a WritableFS that reads and writes migration files in a directory on disk
+type DirSource struct {
+	fs.FS
+	path string
+}
+
+// NewDirSource creates a *DirSource for the migration directory at path
+func NewDirSource(path string) *DirSource {
+	return &DirSource{
+		FS:   os.DirFS(path),
+		path: path,
+	}
+}
+
+// WriteFile writes data to the named file in the migration directory. The directory is created if it does not exist
+func (s *DirSource) WriteFile(name string, data []byte) error {
+	err := os.MkdirAll(s.path, 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.Path(name), data, 0644)
+}
+
+// Path returns the path on disk of the migration file name
+func (s *DirSource) Path(name string) string {
+	return filepath.Join(s.path, name)
+}
+
+// sourcePath returns the path of the migration file name in source. Only a DirSource knows the path of its files, for
+// other sources name is returned
+func sourcePath(source fs.FS, name string) string {
+	if dir, ok := source.(*DirSource); ok {
+		return dir.Path(name)
+	}
+
+	return name
+}
@@ -0,0 +1,250 @@
+package migrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dhanekom/dbmigrator/models"
+)
+
+const (
+	STATUS_APPLIED  = "applied"
+	STATUS_PENDING  = "pending"
+	STATUS_GAP      = "gap"
+	STATUS_ORPHANED = "orphaned"
+	STATUS_DIRTY    = "dirty"
+
+	FORMAT_TABLE = "table"
+	FORMAT_JSON  = "json"
+)
+
+// notInitialisedMessage is reported by the read-only commands, which do not create the migration table, when the
+// migration table does not exist
+const notInitialisedMessage = "migration table not initialised"
+
+// MigrationStatus describes the state of a single migration version
+type MigrationStatus struct {
+	Version         string     `json:"version"`
+	Desc            string     `json:"description"`
+	Status          string     `json:"status"`
+	MissingUpFile   bool       `json:"missing_up_file"`
+	MissingDownFile bool       `json:"missing_down_file"`
+	GoMigration     bool       `json:"go_migration"`
+	AppliedAt       *time.Time `json:"applied_at,omitempty"`
+	DurationMs      int64      `json:"duration_ms,omitempty"`
+	DBUser          string     `json:"db_user,omitempty"`
+	OSUser          string     `json:"os_user,omitempty"`
+	Hostname        string     `json:"hostname,omitempty"`
+	ToolVersion     string     `json:"tool_version,omitempty"`
+	Checksum        string     `json:"checksum,omitempty"`
+}
+
+// StatusReport describes the state of all migration versions found in the migration directory and the migration table
+type StatusReport struct {
+	CurrentVersion string            `json:"current_version"`
+	Initialised    bool              `json:"initialised"`
+	Migrations     []MigrationStatus `json:"migrations"`
+}
+
+// Status connects to the DB and returns a StatusReport that describes the state of every migration version. Status does
+// not create the migration table. If it does not exist the report is not initialised and all migrations are pending
+func (m *Migrator) Status() (*StatusReport, error) {
+	return m.StatusContext(context.Background())
+}
+
+// StatusContext is like Status but uses ctx for all db operations
+func (m *Migrator) StatusContext(ctx context.Context) (*StatusReport, error) {
+	funcPrefix := "status"
+
+	err := m.DBRepository.ConnectToDBContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	defer func() {
+		m.DBRepository.CloseDB()
+	}()
+
+	tableExists, err := m.DBRepository.MigrationTableExistsContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	if !tableExists {
+		mvMap, err := m.GetMigrationVersionInfoMap()
+		if err != nil {
+			return nil, fmt.Errorf(funcPrefix+" - %w", err)
+		}
+
+		m.report(Event{Type: EVENT_NOTICE, Message: notInitialisedMessage})
+		return NewStatusReport(sortMigrationVersions(mvMap), ""), nil
+	}
+
+	mvs, err := m.GetMigrationVersionInfoContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	currentVersion, err := m.DBRepository.CurrentVersionContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	report := NewStatusReport(mvs, currentVersion)
+	report.Initialised = true
+	return report, nil
+}
+
+// NewStatusReport builds a StatusReport from mvs, which must be sorted by version
+func NewStatusReport(mvs []models.MigrationVersion, currentVersion string) *StatusReport {
+	report := StatusReport{
+		CurrentVersion: currentVersion,
+		Migrations:     make([]MigrationStatus, 0, len(mvs)),
+	}
+
+	for _, mv := range mvs {
+		ms := MigrationStatus{
+			Version:         mv.Version,
+			Desc:            mv.Desc,
+			MissingUpFile:   !mv.HasMigration(DIRECTION_UP),
+			MissingDownFile: !mv.HasMigration(DIRECTION_DOWN),
+			GoMigration:     mv.IsGoMigration(),
+		}
+
+		switch {
+		case mv.Dirty:
+			ms.Status = STATUS_DIRTY
+		case mv.ExistsInDB && !mv.HasMigration(DIRECTION_UP) && !mv.HasMigration(DIRECTION_DOWN):
+			ms.Status = STATUS_ORPHANED
+		case mv.ExistsInDB:
+			ms.Status = STATUS_APPLIED
+		case mv.Version < currentVersion:
+			ms.Status = STATUS_GAP
+		default:
+			ms.Status = STATUS_PENDING
+		}
+
+		if mv.ExistsInDB {
+			appliedAt := mv.AppliedAt
+			ms.AppliedAt = &appliedAt
+			ms.DurationMs = mv.Duration.Milliseconds()
+			ms.DBUser = mv.DBUser
+			ms.OSUser = mv.OSUser
+			ms.Hostname = mv.Hostname
+			ms.ToolVersion = mv.ToolVersion
+			ms.Checksum = mv.Checksum
+		}
+
+		report.Migrations = append(report.Migrations, ms)
+	}
+
+	return &report
+}
+
+// Render writes the report to w in the specified format (table or json)
+func (r StatusReport) Render(w io.Writer, format string) error {
+	return render(w, format, r.WriteTable, r)
+}
+
+// render writes v to w in the specified format, as a table with table or as indented JSON. It is shared by the Render
+// methods of the reports
+func render(w io.Writer, format string, table func(io.Writer) error, v any) error {
+	switch strings.ToLower(format) {
+	case FORMAT_TABLE, "":
+		return table(w)
+	case FORMAT_JSON:
+		return writeJSON(w, v)
+	default:
+		return fmt.Errorf("render - %q is not a valid format. Value must be one of the following (%s, %s)", format, FORMAT_TABLE, FORMAT_JSON)
+	}
+}
+
+// writeJSON writes v to w as indented JSON
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// WriteTable writes the report to w as a human readable table
+func (r StatusReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tDESCRIPTION\tTYPE\tSTATUS\tAPPLIED AT\tDURATION\tAPPLIED BY\tMISSING FILES")
+	for _, ms := range r.Migrations {
+		appliedAt, duration, appliedBy := "-", "-", "-"
+		if ms.AppliedAt != nil {
+			appliedAt = ms.AppliedAt.Format("2006-01-02 15:04:05")
+			duration = (time.Duration(ms.DurationMs) * time.Millisecond).String()
+			appliedBy = appliedByText(ms)
+		}
+
+		migrationType := "sql"
+		if ms.GoMigration {
+			migrationType = "go"
+		}
+
+		var missingFiles []string
+		if ms.MissingUpFile {
+			missingFiles = append(missingFiles, DIRECTION_UP)
+		}
+		if ms.MissingDownFile {
+			missingFiles = append(missingFiles, DIRECTION_DOWN)
+		}
+		if len(missingFiles) == 0 {
+			missingFiles = append(missingFiles, "-")
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", ms.Version, ms.Desc, migrationType, ms.Status, appliedAt, duration, appliedBy, strings.Join(missingFiles, ", "))
+	}
+
+	if r.Initialised {
+		fmt.Fprintf(tw, "\ncurrent version: %s\n", r.CurrentVersion)
+	} else {
+		fmt.Fprintf(tw, "\n%s\n", notInitialisedMessage)
+	}
+	return tw.Flush()
+}
+
+// appliedByText describes who applied a migration as "os user@hostname (db user)". Migrations that were applied before
+// the metadata was recorded are shown as "-"
+func appliedByText(ms MigrationStatus) string {
+	text := ms.OSUser
+	if ms.Hostname != "" {
+		text += "@" + ms.Hostname
+	}
+
+	if ms.DBUser != "" {
+		text = strings.TrimSpace(text + " (" + ms.DBUser + ")")
+	}
+
+	if text == "" {
+		return "-"
+	}
+
+	return text
+}
+
+// WriteJSON writes the report to w as indented JSON
+func (r StatusReport) WriteJSON(w io.Writer) error {
+	return writeJSON(w, r)
+}
+
+// List writes a status report of all migration versions to w in the specified format (table or json)
+func (m *Migrator) List(w io.Writer, format string) error {
+	return m.ListContext(context.Background(), w, format)
+}
+
+// ListContext is like List but uses ctx for all db operations
+func (m *Migrator) ListContext(ctx context.Context, w io.Writer, format string) error {
+	report, err := m.StatusContext(ctx)
+	if err != nil {
+		return fmt.Errorf("list - %w", err)
+	}
+
+	return report.Render(w, format)
+}
@@ -0,0 +1,78 @@
+package migrator
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/dhanekom/dbmigrator/models"
+)
+
+// TEMPLATE_ENV_PREFIX is the prefix of environment variables that are available in migration templates. The prefix is
+// removed from the variable name, e.g. DBMIGRATOR_VAR_schema is available as {{.schema}}
+const TEMPLATE_ENV_PREFIX = "DBMIGRATOR_VAR_"
+
+// templateVars returns the variables that are available in migration templates. Variables set in
+// AppConfig.TemplateVars override environment variables with the same name
+func (m Migrator) templateVars() map[string]string {
+	vars := make(map[string]string)
+	for _, env := range os.Environ() {
+		name, value, ok := strings.Cut(env, "=")
+		if ok && strings.HasPrefix(name, TEMPLATE_ENV_PREFIX) && len(name) > len(TEMPLATE_ENV_PREFIX) {
+			vars[strings.TrimPrefix(name, TEMPLATE_ENV_PREFIX)] = value
+		}
+	}
+
+	if m.App != nil {
+		for name, value := range m.App.TemplateVars {
+			vars[name] = value
+		}
+	}
+
+	return vars
+}
+
+// renderMigrationScript renders the SQL of script with text/template if the script contains the template directive.
+// Referencing a variable that is not defined is an error
+func (m Migrator) renderMigrationScript(script models.MigrationScript) (models.MigrationScript, error) {
+	if !script.Template {
+		return script, nil
+	}
+
+	tmpl, err := template.New(script.Filename).Option("missingkey=error").Parse(script.SQL)
+	if err != nil {
+		return script, fmt.Errorf("rendering %s - %w", script.Filename, err)
+	}
+
+	var sb strings.Builder
+	err = tmpl.Execute(&sb, m.templateVars())
+	if err != nil {
+		return script, fmt.Errorf("rendering %s - %w", script.Filename, err)
+	}
+
+	script.SQL = sb.String()
+	return script, nil
+}
+
+// readMigrationScript reads the migration file filename, parses its directives and renders it if it is a template. The
+// raw file content is returned as well for calculating the checksum
+func (m Migrator) readMigrationScript(filename string) (models.MigrationScript, []byte, error) {
+	data, err := fs.ReadFile(m.source, filename)
+	if err != nil {
+		return models.MigrationScript{}, nil, err
+	}
+
+	script, err := parseMigrationScript(filename, data)
+	if err != nil {
+		return script, nil, err
+	}
+
+	script, err = m.renderMigrationScript(script)
+	if err != nil {
+		return script, nil, err
+	}
+
+	return script, data, nil
+}
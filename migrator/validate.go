@@ -0,0 +1,219 @@
+package migrator
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	ISSUE_INVALID_FILENAME  = "invalid_filename"
+	ISSUE_INVALID_VERSION   = "invalid_version"
+	ISSUE_FUTURE_VERSION    = "future_version"
+	ISSUE_DUPLICATE_VERSION = "duplicate_version"
+	ISSUE_DUPLICATE_DESC    = "duplicate_description"
+	ISSUE_MISSING_UP_FILE   = "missing_up_file"
+	ISSUE_MISSING_DOWN_FILE = "missing_down_file"
+	ISSUE_EMPTY_FILE        = "empty_file"
+	ISSUE_INVALID_DIRECTIVE = "invalid_directive"
+	ISSUE_INVALID_SQL       = "invalid_sql"
+	ISSUE_INVALID_TEMPLATE  = "invalid_template"
+)
+
+// ValidationIssue describes a single problem found in the migration source
+type ValidationIssue struct {
+	Type     string `json:"type"`
+	Version  string `json:"version,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	Message  string `json:"message"`
+}
+
+// ValidationResult holds all the problems found in the migration source. Valid is true when no issues were found
+type ValidationResult struct {
+	Valid  bool              `json:"valid"`
+	Issues []ValidationIssue `json:"issues"`
+}
+
+// Validate checks the migration source for problems that would otherwise only be discovered while migrating. It reports
+// .sql files with invalid names, versions that are not valid or lie in the future, versions with more than one
+// description, descriptions used by more than one version, versions without an up or down migration, empty files,
+// invalid directives, templates that cannot be rendered and SQL that cannot be split into statements (only if the
+// Migrator has a DBRepository).
+// The db is not accessed. An error is only returned if the migration source cannot be read
+func (m *Migrator) Validate() (*ValidationResult, error) {
+	funcPrefix := "validate"
+
+	files, err := fs.ReadDir(m.source, ".")
+	if err != nil {
+		return nil, fmt.Errorf(funcPrefix+" - getting migration filenames - %w", err)
+	}
+
+	result := ValidationResult{Issues: make([]ValidationIssue, 0)}
+	addIssue := func(issueType, version, filename, format string, a ...any) {
+		result.Issues = append(result.Issues, ValidationIssue{
+			Type:     issueType,
+			Version:  version,
+			Filename: filename,
+			Message:  fmt.Sprintf(format, a...),
+		})
+	}
+
+	type versionInfo struct {
+		descs      map[string]bool
+		directions map[string]bool
+	}
+	versions := make(map[string]*versionInfo)
+	getVersionInfo := func(version string) *versionInfo {
+		vi, ok := versions[version]
+		if !ok {
+			vi = &versionInfo{descs: make(map[string]bool), directions: make(map[string]bool)}
+			versions[version] = vi
+		}
+
+		return vi
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.EqualFold(path.Ext(file.Name()), ".sql") {
+			continue
+		}
+
+		matches := re.FindStringSubmatch(file.Name())
+		if matches == nil {
+			addIssue(ISSUE_INVALID_FILENAME, "", file.Name(), "%s does not match the yyyymmdd_hhnnss_description.(up|down).sql format", file.Name())
+			continue
+		}
+
+		version, desc, direction := matches[1], matches[2], matches[3]
+		vi := getVersionInfo(version)
+		vi.descs[desc] = true
+		vi.directions[direction] = true
+
+		data, err := fs.ReadFile(m.source, file.Name())
+		if err != nil {
+			return nil, fmt.Errorf(funcPrefix+" - %w", err)
+		}
+
+		if strings.TrimSpace(string(data)) == "" {
+			addIssue(ISSUE_EMPTY_FILE, version, file.Name(), "%s is empty", file.Name())
+		}
+
+		script, err := parseMigrationScript(file.Name(), data)
+		if err != nil {
+			addIssue(ISSUE_INVALID_DIRECTIVE, version, file.Name(), "%s", err)
+			continue
+		}
+
+		script, err = m.renderMigrationScript(script)
+		if err != nil {
+			addIssue(ISSUE_INVALID_TEMPLATE, version, file.Name(), "%s", err)
+			continue
+		}
+
+		if m.DBRepository != nil {
+			if _, err := m.DBRepository.SplitStatements(script.SQL); err != nil {
+				addIssue(ISSUE_INVALID_SQL, version, file.Name(), "%s - %s", file.Name(), err)
+			}
+		}
+	}
+
+	for _, goMigration := range m.goMigrations {
+		vi := getVersionInfo(goMigration.Version)
+		vi.descs[goMigration.Desc] = true
+		for _, direction := range []string{DIRECTION_UP, DIRECTION_DOWN} {
+			if goMigration.Func(direction) != nil {
+				vi.directions[direction] = true
+			}
+		}
+	}
+
+	versionsByDesc := make(map[string][]string)
+	now := time.Now()
+	for version, vi := range versions {
+		t, err := time.ParseInLocation("20060102_150405", version, time.Local)
+		if err != nil {
+			addIssue(ISSUE_INVALID_VERSION, version, "", "version %s is not a valid date and time", version)
+		} else if t.After(now) {
+			addIssue(ISSUE_FUTURE_VERSION, version, "", "version %s lies in the future", version)
+		}
+
+		if len(vi.descs) > 1 {
+			addIssue(ISSUE_DUPLICATE_VERSION, version, "", "version %s is used with more than one description (%s)", version, strings.Join(sortedKeys(vi.descs), ", "))
+		}
+
+		for desc := range vi.descs {
+			versionsByDesc[desc] = append(versionsByDesc[desc], version)
+		}
+
+		if !vi.directions[DIRECTION_UP] {
+			addIssue(ISSUE_MISSING_UP_FILE, version, "", "version %s does not have an up migration", version)
+		}
+		if !vi.directions[DIRECTION_DOWN] {
+			addIssue(ISSUE_MISSING_DOWN_FILE, version, "", "version %s does not have a down migration", version)
+		}
+	}
+
+	for desc, descVersions := range versionsByDesc {
+		if len(descVersions) > 1 {
+			sort.Strings(descVersions)
+			addIssue(ISSUE_DUPLICATE_DESC, "", "", "description %q is used by more than one version (%s)", desc, strings.Join(descVersions, ", "))
+		}
+	}
+
+	sort.SliceStable(result.Issues, func(i, j int) bool {
+		if result.Issues[i].Version != result.Issues[j].Version {
+			return result.Issues[i].Version < result.Issues[j].Version
+		}
+
+		if result.Issues[i].Filename != result.Issues[j].Filename {
+			return result.Issues[i].Filename < result.Issues[j].Filename
+		}
+
+		return result.Issues[i].Message < result.Issues[j].Message
+	})
+
+	result.Valid = len(result.Issues) == 0
+	return &result, nil
+}
+
+// Render writes the result to w in the specified format (table or json)
+func (r ValidationResult) Render(w io.Writer, format string) error {
+	return render(w, format, r.WriteTable, r)
+}
+
+// WriteTable writes the result to w as a human readable table
+func (r ValidationResult) WriteTable(w io.Writer) error {
+	if r.Valid {
+		_, err := fmt.Fprintln(w, "no issues found")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tMESSAGE")
+	for _, issue := range r.Issues {
+		fmt.Fprintf(tw, "%s\t%s\n", issue.Type, issue.Message)
+	}
+
+	return tw.Flush()
+}
+
+// WriteJSON writes the result to w as indented JSON
+func (r ValidationResult) WriteJSON(w io.Writer) error {
+	return writeJSON(w, r)
+}
+
+// sortedKeys returns the keys of m in sorted order
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
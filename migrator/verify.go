@@ -0,0 +1,125 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/dhanekom/dbmigrator/models"
+)
+
+// ChecksumMismatch describes an applied migration version whose up migration file was modified after it was applied
+type ChecksumMismatch struct {
+	Version        string `json:"version"`
+	Filename       string `json:"filename"`
+	StoredChecksum string `json:"stored_checksum"`
+	FileChecksum   string `json:"file_checksum"`
+}
+
+// Verify compares the checksums that were stored when migrations were applied with the checksums of the current up
+// migration files and returns the migrations that were modified. Only the checksum of the up migration file is stored,
+// so modified down migration files are not detected. Versions without a stored checksum (e.g. forced versions or
+// versions applied before checksums were recorded) and Go migrations are skipped. Verify does not create the
+// migration table. If it does not exist no migrations were applied and no mismatches are returned
+func (m *Migrator) Verify() ([]ChecksumMismatch, error) {
+	return m.VerifyContext(context.Background())
+}
+
+// VerifyContext is like Verify but uses ctx for all db operations
+func (m *Migrator) VerifyContext(ctx context.Context) ([]ChecksumMismatch, error) {
+	funcPrefix := "verify"
+
+	err := m.DBRepository.ConnectToDBContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	defer func() {
+		m.DBRepository.CloseDB()
+	}()
+
+	tableExists, err := m.DBRepository.MigrationTableExistsContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	if !tableExists {
+		m.report(Event{Type: EVENT_NOTICE, Message: notInitialisedMessage})
+		return make([]ChecksumMismatch, 0), nil
+	}
+
+	mvs, err := m.GetMigrationVersionInfoContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	mismatches, err := m.findChecksumMismatches(mvs)
+	if err != nil {
+		return nil, fmt.Errorf(funcPrefix+" - %w", err)
+	}
+
+	if len(mismatches) == 0 {
+		m.report(Event{Type: EVENT_COMPLETED, Message: "all applied migration files match their stored checksums"})
+	}
+
+	return mismatches, nil
+}
+
+// findChecksumMismatches returns the applied migrations in mvs whose up migration file checksum differs from the
+// stored checksum. Down migration files are not checked
+func (m *Migrator) findChecksumMismatches(mvs []models.MigrationVersion) ([]ChecksumMismatch, error) {
+	mismatches := make([]ChecksumMismatch, 0)
+	for _, mv := range mvs {
+		if !mv.ExistsInDB || mv.Checksum == "" || !mv.UpFileExists {
+			continue
+		}
+
+		filename := mv.Filename(DIRECTION_UP)
+		data, err := fs.ReadFile(m.source, filename)
+		if err != nil {
+			return nil, err
+		}
+
+		fileChecksum := checksum(data)
+		if fileChecksum != mv.Checksum {
+			mismatches = append(mismatches, ChecksumMismatch{
+				Version:        mv.Version,
+				Filename:       filename,
+				StoredChecksum: mv.Checksum,
+				FileChecksum:   fileChecksum,
+			})
+		}
+	}
+
+	return mismatches, nil
+}
+
+// checkModifiedMigrations returns an error if App.RefuseModifiedMigrations is set and applied migration files in mvs
+// were modified since they were applied
+func (m *Migrator) checkModifiedMigrations(mvs []models.MigrationVersion) error {
+	if !m.App.RefuseModifiedMigrations {
+		return nil
+	}
+
+	mismatches, err := m.findChecksumMismatches(mvs)
+	if err != nil {
+		return err
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d applied migration file(s) have been modified since they were applied (%s)", len(mismatches), mismatchFilenames(mismatches))
+	}
+
+	return nil
+}
+
+// mismatchFilenames returns the filenames of mismatches as a comma separated list
+func mismatchFilenames(mismatches []ChecksumMismatch) string {
+	filenames := make([]string, 0, len(mismatches))
+	for _, mismatch := range mismatches {
+		filenames = append(filenames, mismatch.Filename)
+	}
+
+	return strings.Join(filenames, ", ")
+}
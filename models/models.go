@@ -1,13 +1,88 @@
 package models
 
-import "fmt"
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// MigrationFunc is a migration written in Go. It is run in the same transaction that records the migration version
+type MigrationFunc func(tx *sql.Tx) error
 
 type MigrationVersion struct {
-	Version          string
-	Desc             string
-	ExistsInDB       bool
-	UpFileExists     bool
-	DownFileExists 	 bool
+	Version        string
+	Desc           string
+	ExistsInDB     bool
+	UpFileExists   bool
+	DownFileExists bool
+	UpFunc         MigrationFunc
+	DownFunc       MigrationFunc
+	AppliedAt      time.Time
+	Checksum       string
+	Dirty          bool
+	Duration       time.Duration
+	DBUser         string
+	OSUser         string
+	Hostname       string
+	ToolVersion    string
+}
+
+// AppliedMigration holds the details of a migration version that has been recorded in the migration table
+type AppliedMigration struct {
+	Version     string
+	CreatedOn   time.Time
+	Checksum    string
+	Dirty       bool
+	Description string
+	Duration    time.Duration
+	DBUser      string
+	OSUser      string
+	Hostname    string
+	ToolVersion string
+}
+
+// MigrationScript holds the SQL of a migration file and the options set by the directives in its header
+type MigrationScript struct {
+	Filename      string
+	SQL           string
+	NoTransaction bool
+	Template      bool
+}
+
+// MigrationRecord holds the details that are recorded in the migration table when a migration version is applied.
+// Duration is measured by the DBRepo and the db user is determined by the db
+type MigrationRecord struct {
+	Version     string
+	Checksum    string
+	Description string
+	Duration    time.Duration
+	OSUser      string
+	Hostname    string
+	ToolVersion string
+}
+
+// HistoryEntry is a row of the migration history table. A row is added for every migration step that is run,
+// whether it succeeded or failed
+type HistoryEntry struct {
+	ID          int64
+	Version     string
+	Direction   string
+	Command     string
+	Outcome     string
+	Error       string
+	Duration    time.Duration
+	DBUser      string
+	OSUser      string
+	Hostname    string
+	ToolVersion string
+	CreatedOn   time.Time
+}
+
+// HistoryFilter limits the history entries returned by a history query. Empty fields are not used to filter
+type HistoryFilter struct {
+	Version string
+	From    time.Time
+	To      time.Time
 }
 
 func (mv MigrationVersion) Filename(migrationDirection string) string {
@@ -22,4 +97,30 @@ func (mv MigrationVersion) FileExists(migrationDirection string) bool {
 	} else {
 		return false
 	}
-}
\ No newline at end of file
+}
+
+// Func returns the Go migration function for the migration direction or nil if the version has no Go migration
+func (mv MigrationVersion) Func(migrationDirection string) MigrationFunc {
+	if migrationDirection == "up" {
+		return mv.UpFunc
+	} else if migrationDirection == "down" {
+		return mv.DownFunc
+	} else {
+		return nil
+	}
+}
+
+// HasMigration reports whether the version has a migration file or a Go migration for the migration direction
+func (mv MigrationVersion) HasMigration(migrationDirection string) bool {
+	return mv.FileExists(migrationDirection) || mv.Func(migrationDirection) != nil
+}
+
+// IsGoMigration reports whether the version has a Go migration for any direction
+func (mv MigrationVersion) IsGoMigration() bool {
+	return mv.UpFunc != nil || mv.DownFunc != nil
+}
+
+// GoName returns the name that is used to refer to the Go migration of the migration direction
+func (mv MigrationVersion) GoName(migrationDirection string) string {
+	return fmt.Sprintf("%s_%s.%s.go", mv.Version, mv.Desc, migrationDirection)
+}